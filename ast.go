@@ -0,0 +1,343 @@
+package treepath
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An Expr is a node of the syntax tree of a path: a *UnionExpr, a *PathExpr,
+// a *SliceExpr filter, or an expression of a filter, as a *BinaryExpr,
+// *AttrExpr, *LiteralExpr, *NumberExpr, *VarExpr or *CallExpr.
+//
+// The String method of a node returns its canonical form, that can be
+// compiled back to the node.
+type Expr interface {
+	String() string
+	isExpr()
+}
+
+// UnionExpr is the union of several paths separated by "|".
+type UnionExpr struct {
+	Paths []*PathExpr
+}
+
+// PathExpr is a path made of steps separated by "/". An Absolute path
+// starts from the root of the tree. The "//" separator is represented
+// by an abbreviated descendant-or-self step, as in XPath.
+type PathExpr struct {
+	Absolute bool
+	Steps    []*Step
+}
+
+// Step is a step of a path: an Axis, a node Test and zero or more
+// [Filters]. The node test is a tag, or "*" to match any element.
+//
+// The last step of a path can take a value of the elements instead:
+// the attribute axis takes the attribute named by the node test, or all
+// the attributes if it is "*", and the "text()" node test of the child
+// axis takes the text. These steps have no filters.
+//
+// Abbrev is true for the abbreviated steps: "." (self::*), ".." (parent::*),
+// "//" (descendant-or-self::*), tag and "*" (child::tag and child::*),
+// and @attr (attribute::attr).
+// The abbreviated "//" step selects the descendants in breadth-first order,
+// and the positions of the filters of the next step count the children of
+// each descendant: //li[1] selects the first li child of each parent.
+type Step struct {
+	Axis    string
+	Test    string
+	Abbrev  bool
+	Filters []Expr
+}
+
+// BinaryExpr is an "or", "and" or comparison operator between two
+// expressions. The comparison operators are "=", "!=", "<", "<=", ">"
+// and ">=", and "~=" matching a regular expression.
+type BinaryExpr struct {
+	Op          string
+	Left, Right Expr
+}
+
+// AttrExpr is an @attr expression. The Name can be "*" to take
+// all the attributes.
+type AttrExpr struct {
+	Name string
+}
+
+// LiteralExpr is a 'text' value.
+type LiteralExpr struct {
+	Value string
+}
+
+// NumberExpr is a number value.
+type NumberExpr struct {
+	Value float64
+}
+
+// VarExpr is a $variable, bound when the path is evaluated.
+type VarExpr struct {
+	Name string
+}
+
+// CallExpr is a call to a function.
+type CallExpr struct {
+	Name string
+	Args []Expr
+}
+
+// SliceExpr is a [start:stop:step] filter, selecting the elements from
+// index Start to index Stop excluded, taking one every Step, as the
+// slices of Python do. Indexes start from 0, unlike the positions of the
+// [pos] filters, and negative indexes count from the end, so [-3:] selects
+// the last three elements, and a negative Step selects the elements
+// backward, as [::-1]. Nil values are omitted: the whole list, with a
+// step of 1.
+type SliceExpr struct {
+	Start, Stop, Step *int
+}
+
+func (*UnionExpr) isExpr()   {}
+func (*PathExpr) isExpr()    {}
+func (*BinaryExpr) isExpr()  {}
+func (*AttrExpr) isExpr()    {}
+func (*LiteralExpr) isExpr() {}
+func (*NumberExpr) isExpr()  {}
+func (*VarExpr) isExpr()     {}
+func (*CallExpr) isExpr()    {}
+func (*SliceExpr) isExpr()   {}
+
+// String returns the paths separated by " | ".
+func (x *UnionExpr) String() string {
+	paths := make([]string, len(x.Paths))
+	for j, p := range x.Paths {
+		paths[j] = p.String()
+	}
+	return strings.Join(paths, " | ")
+}
+
+// String returns the steps separated by "/", using the "//" separator
+// for the abbreviated descendant-or-self steps.
+func (x *PathExpr) String() string {
+	var b strings.Builder
+	if x.Absolute {
+		b.WriteString("/")
+	}
+	sep := false // the "/" separator is needed
+	for j, s := range x.Steps {
+		if s.isDescendantSep() && j+1 < len(x.Steps) && !x.Steps[j+1].isDescendantSep() {
+			if j == 0 && x.Absolute {
+				b.WriteString(".")
+			}
+			b.WriteString("//")
+			sep = false
+			continue
+		}
+		if sep {
+			b.WriteString("/")
+		}
+		b.WriteString(s.String())
+		sep = true
+	}
+	return b.String()
+}
+
+// isDescendantSep returns true if the step can be written as the
+// "//" separator.
+func (s *Step) isDescendantSep() bool {
+	return s.Abbrev && s.Axis == "descendant-or-self" && s.Test == "*" && len(s.Filters) == 0
+}
+
+// isValue returns true if the step takes a value of the elements.
+func (s *Step) isValue() bool {
+	return s.Axis == "attribute" || s.Axis == "child" && s.Test == "text()"
+}
+
+// String returns the step in abbreviated form if possible,
+// followed by its filters.
+func (s *Step) String() string {
+	var b strings.Builder
+	switch {
+	case s.Axis == "child":
+		b.WriteString(s.Test)
+	case s.Abbrev && s.Axis == "self" && s.Test == "*":
+		b.WriteString(".")
+	case s.Abbrev && s.Axis == "parent" && s.Test == "*":
+		b.WriteString("..")
+	case s.Abbrev && s.Axis == "attribute":
+		b.WriteString("@" + s.Test)
+	default:
+		b.WriteString(s.Axis + "::" + s.Test)
+	}
+	for _, f := range s.Filters {
+		b.WriteString("[" + f.String() + "]")
+	}
+	return b.String()
+}
+
+// precedence returns the precedence of the operator of x:
+// "or" binds less than "and", that binds less than the comparisons.
+func precedence(x Expr) int {
+	if x, ok := x.(*BinaryExpr); ok {
+		switch x.Op {
+		case "or":
+			return 1
+		case "and":
+			return 2
+		}
+		return 3
+	}
+	return 4
+}
+
+// String returns the operands separated by the operator, enclosing
+// them in (parentheses) where needed.
+func (x *BinaryExpr) String() string {
+	p := precedence(x)
+	left, right := x.Left.String(), x.Right.String()
+	if precedence(x.Left) < p || p == 3 && precedence(x.Left) == p {
+		left = "(" + left + ")"
+	}
+	if precedence(x.Right) <= p {
+		right = "(" + right + ")"
+	}
+	if p == 3 {
+		return left + x.Op + right
+	}
+	return left + " " + x.Op + " " + right
+}
+
+// String returns @name.
+func (x *AttrExpr) String() string {
+	return "@" + x.Name
+}
+
+// String returns the value enclosed in single quotes, or in double
+// quotes if it contains only single quotes. The backslashes that would
+// be taken as escapes are escaped.
+func (x *LiteralExpr) String() string {
+	q := byte('\'')
+	if strings.IndexByte(x.Value, '\'') >= 0 && strings.IndexByte(x.Value, '"') < 0 {
+		q = '"'
+	}
+	b := []byte{q}
+	for j := 0; j < len(x.Value); j++ {
+		switch ch := x.Value[j]; {
+		case ch == q:
+			b = append(b, '\\', ch)
+		case ch == '\\' && (j+1 == len(x.Value) || isEscaped(x.Value[j+1])):
+			b = append(b, '\\', ch)
+		default:
+			b = append(b, ch)
+		}
+	}
+	return string(append(b, q))
+}
+
+// String returns the shortest decimal representation of the number.
+func (x *NumberExpr) String() string {
+	return formatNumber(x.Value)
+}
+
+// String returns start:stop:step, without the omitted values.
+func (x *SliceExpr) String() string {
+	var b strings.Builder
+	if x.Start != nil {
+		b.WriteString(strconv.Itoa(*x.Start))
+	}
+	b.WriteString(":")
+	if x.Stop != nil {
+		b.WriteString(strconv.Itoa(*x.Stop))
+	}
+	if x.Step != nil {
+		b.WriteString(":" + strconv.Itoa(*x.Step))
+	}
+	return b.String()
+}
+
+// String returns $name.
+func (x *VarExpr) String() string {
+	return "$" + x.Name
+}
+
+// String returns the name of the function followed by its
+// arguments separated by ", ".
+func (x *CallExpr) String() string {
+	args := make([]string, len(x.Args))
+	for j, a := range x.Args {
+		args[j] = a.String()
+	}
+	return x.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// invalidToken returns the first name, operator or number of the tree x
+// that cannot be written in its canonical form, and whether there is one.
+// The names of tags, attributes, axes, variables and functions must be
+// made of name characters, and the numbers must be finite.
+func invalidToken(x Expr) (string, bool) {
+	var args []Expr
+	switch x := x.(type) {
+	case *UnionExpr:
+		for _, p := range x.Paths {
+			args = append(args, p)
+		}
+	case *PathExpr:
+		for _, s := range x.Steps {
+			if !isName(s.Axis) {
+				return s.Axis, true
+			}
+			if !isPattern(s.Test) && !(s.Axis == "child" && s.Test == "text()") {
+				return s.Test, true
+			}
+			args = append(args, s.Filters...)
+		}
+	case *BinaryExpr:
+		switch x.Op {
+		case "or", "and", "=", "!=", "<", "<=", ">", ">=", "~=":
+		default:
+			return x.Op, true
+		}
+		args = []Expr{x.Left, x.Right}
+	case *AttrExpr:
+		if !isPattern(x.Name) {
+			return x.Name, true
+		}
+	case *VarExpr:
+		if !isName(x.Name) {
+			return x.Name, true
+		}
+	case *NumberExpr:
+		if math.IsNaN(x.Value) || math.IsInf(x.Value, 0) {
+			return x.String(), true
+		}
+	case *CallExpr:
+		if !isName(x.Name) {
+			return x.Name, true
+		}
+		args = x.Args
+	case *SliceExpr:
+		if x.Step != nil && *x.Step == 0 {
+			return x.String(), true
+		}
+	}
+	for _, a := range args {
+		if tok, ok := invalidToken(a); ok {
+			return tok, true
+		}
+	}
+	return "", false
+}
+
+// isName returns true if s is a valid tag, attribute, axis, variable or
+// function name.
+func isName(s string) bool {
+	if s == "" || !isNameStart(s[0]) || strings.Contains(s, "::") {
+		return false
+	}
+	for j := 1; j < len(s); j++ {
+		if !isNameChar(s[j]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package treepath
+
+// A Builder builds a path step by step, without writing it as a string.
+// For example
+//
+//	treepath.Child("div").Attr("class", "footer").Descendant().Child("p").Pos(-1)
+//
+// builds the path div[@class='footer']//p[-1]. The text values are quoted
+// and escaped as needed, and the names are checked when the path is
+// compiled, so values coming from user input cannot change the path.
+//
+// The methods of a Builder return a new Builder, and leave the receiver
+// unchanged: a partial path can be extended in different ways.
+type Builder struct {
+	x PathExpr
+}
+
+// Root returns a Builder of an absolute path, starting from the root
+// of the tree.
+func Root() *Builder {
+	return &Builder{PathExpr{Absolute: true}}
+}
+
+// Child returns a Builder of a relative path selecting the children
+// having the tag, or any children if tag is "*".
+func Child(tag string) *Builder {
+	return new(Builder).Child(tag)
+}
+
+// Descendant returns a Builder of a relative path starting with "//".
+func Descendant() *Builder {
+	return new(Builder).Descendant()
+}
+
+// step returns a copy of the builder with the step s appended.
+func (b *Builder) step(s *Step) *Builder {
+	steps := b.x.Steps[:len(b.x.Steps):len(b.x.Steps)]
+	return &Builder{PathExpr{b.x.Absolute, append(steps, s)}}
+}
+
+// filter returns a copy of the builder with the filter f added to the
+// last step. A path without steps gets a "." step first.
+func (b *Builder) filter(f Expr) *Builder {
+	if len(b.x.Steps) == 0 {
+		b = b.Self()
+	}
+	steps := append([]*Step(nil), b.x.Steps...)
+	last := *steps[len(steps)-1]
+	last.Filters = append(last.Filters[:len(last.Filters):len(last.Filters)], f)
+	steps[len(steps)-1] = &last
+	return &Builder{PathExpr{b.x.Absolute, steps}}
+}
+
+// Child appends a step selecting the children having the tag,
+// or any children if tag is "*".
+func (b *Builder) Child(tag string) *Builder {
+	return b.step(&Step{Axis: "child", Test: tag, Abbrev: true})
+}
+
+// Descendant appends the "//" separator: the next step is applied to the
+// element and to all its descendants.
+func (b *Builder) Descendant() *Builder {
+	return b.step(&Step{Axis: "descendant-or-self", Test: "*", Abbrev: true})
+}
+
+// Self appends the "." step.
+func (b *Builder) Self() *Builder {
+	return b.step(&Step{Axis: "self", Test: "*", Abbrev: true})
+}
+
+// Parent appends the ".." step.
+func (b *Builder) Parent() *Builder {
+	return b.step(&Step{Axis: "parent", Test: "*", Abbrev: true})
+}
+
+// Axis appends an axis::tag step. The tag can be "*" to select
+// any element along the axis.
+func (b *Builder) Axis(axis, tag string) *Builder {
+	return b.step(&Step{Axis: axis, Test: tag})
+}
+
+// Attr adds the [@attr='text'] filter to the last step.
+func (b *Builder) Attr(attr, text string) *Builder {
+	return b.filter(&BinaryExpr{"=", &AttrExpr{attr}, &LiteralExpr{text}})
+}
+
+// HasAttr adds the [@attr] filter to the last step.
+func (b *Builder) HasAttr(attr string) *Builder {
+	return b.filter(&AttrExpr{attr})
+}
+
+// Text adds the [text()='text'] filter to the last step.
+func (b *Builder) Text(text string) *Builder {
+	return b.filter(&BinaryExpr{"=", &CallExpr{"text", nil}, &LiteralExpr{text}})
+}
+
+// Pos adds the [pos] filter to the last step. Positions start from 1,
+// and negative positions count from the end: -1 is the last element.
+// Pos(0) selects no element, as in XPath.
+func (b *Builder) Pos(pos int) *Builder {
+	return b.filter(&NumberExpr{float64(pos)})
+}
+
+// Slice adds the [start:stop:step] filter to the last step, as described
+// by SliceExpr. Nil values are omitted: Slice(&n, nil, nil), with n = -3,
+// adds the [-3:] filter.
+func (b *Builder) Slice(start, stop, step *int) *Builder {
+	return b.filter(&SliceExpr{start, stop, step})
+}
+
+// Filter adds a filter with the expression x to the last step.
+func (b *Builder) Filter(x Expr) *Builder {
+	return b.filter(x)
+}
+
+// AST returns the syntax tree of the path. The tree shares its nodes
+// with the builder, so it must not be modified.
+func (b *Builder) AST() *PathExpr {
+	x := b.x
+	return &x
+}
+
+// String returns the canonical form of the path.
+func (b *Builder) String() string {
+	return b.x.String()
+}
+
+// Compile compiles the path.
+// If a name is invalid, the returned error is a *SyntaxError.
+func (b *Builder) Compile() (Path, error) {
+	return CompileAST(b.AST(), CompileOptions{})
+}
+
+// CompileWith is like Compile, but it uses the given options.
+func (b *Builder) CompileWith(opts CompileOptions) (Path, error) {
+	return CompileAST(b.AST(), opts)
+}
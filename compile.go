@@ -0,0 +1,247 @@
+package treepath
+
+import (
+	"math"
+	"regexp"
+)
+
+// compileUnion lowers a union to the segments of each of its paths,
+// and to the values read by them.
+func (c *compiler) compileUnion(u *UnionExpr) ([][]segment, []valueRead) {
+	paths := make([][]segment, 0, len(u.Paths))
+	reads := make([]valueRead, 0, len(u.Paths))
+	for _, p := range u.Paths {
+		segments, read := c.compilePath(p)
+		paths = append(paths, segments)
+		reads = append(reads, read)
+	}
+	return paths, reads
+}
+
+// compilePath lowers a path to its segments, and to the value read from
+// the matched elements: "@attr", "text()", or "" for the elements.
+// A step taking a value selects the elements having the value.
+func (c *compiler) compilePath(p *PathExpr) ([]segment, valueRead) {
+	segments := make([]segment, 0, len(p.Steps)+1)
+	if p.Absolute {
+		segments = append(segments, segment{new(selectRoot), make([]filter, 0)})
+	}
+	for j := 0; j < len(p.Steps); j++ {
+		s := p.Steps[j]
+		switch {
+		case s.Axis == "attribute":
+			attr := c.nameTest(s.Test)
+			segments = append(segments, segment{new(selectSelf), []filter{newFilterAttr(attr)}})
+			return segments, valueRead{"@" + s.Test, attr}
+		case s.isValue():
+			segments = append(segments, segment{new(selectSelf), []filter{newFilterExpr(&exprCall{functions["text"], nil})}})
+			return segments, valueRead{name: "text()"}
+		}
+		sel := c.stepSelector(s)
+		if next := c.subtreeStep(p.Steps[j+1:]); s.isDescendantSep() && next != nil {
+			sel, s = newSelectDescendantsBFS(c.nameTest(next.Test)), next
+			j++
+		}
+		seg := segment{
+			sel:     sel,
+			filters: make([]filter, 0, len(s.Filters)),
+		}
+		for _, x := range s.Filters {
+			seg.filters = append(seg.filters, c.compileFilter(x))
+		}
+		segments = append(segments, seg)
+	}
+	return segments, valueRead{}
+}
+
+// nameTest returns the name test of a tag or attribute name, resolving
+// its namespace prefix with the Namespaces of the compile options.
+func (c *compiler) nameTest(name string) nameTest {
+	return newNameTest(name, c.opts.Namespaces)
+}
+
+// subtreeStep returns the first of the steps if it is a tag or "*" step
+// with filters, to be applied to the whole subtree following a "//" step
+// with the SubtreePositions option. It returns nil otherwise.
+func (c *compiler) subtreeStep(steps []*Step) *Step {
+	if !c.opts.SubtreePositions || len(steps) == 0 {
+		return nil
+	}
+	if s := steps[0]; s.Abbrev && s.Axis == "child" && !s.isValue() && len(s.Filters) > 0 {
+		return s
+	}
+	return nil
+}
+
+// stepSelector returns the selector of a step.
+// The abbreviated ".", ".." and "//" steps select any element,
+// and "//" selects the descendants in breadth-first order.
+func (c *compiler) stepSelector(s *Step) selector {
+	if s.Abbrev {
+		switch s.Axis {
+		case "self":
+			return new(selectSelf)
+		case "parent":
+			return new(selectParent)
+		case "descendant-or-self":
+			return new(selectDescendants)
+		}
+	}
+	return axisSelector(s.Axis, c.nameTest(s.Test))
+}
+
+// axisSelector returns the selector of the elements along the axis having
+// the tag, or nil if the axis is unknown. The tag can be "*" to select
+// any element along the axis.
+func axisSelector(axis string, tag nameTest) selector {
+	switch axis {
+	case "child":
+		if tag.name == "*" {
+			return new(selectChildren)
+		}
+		return newSelectChildrenByTag(tag)
+	case "self":
+		return newSelectSelfByTag(tag)
+	case "parent":
+		return newSelectParentByTag(tag)
+	case "descendant":
+		return newSelectDescendantsByTag(tag, false)
+	case "descendant-or-self":
+		return newSelectDescendantsByTag(tag, true)
+	case "ancestor":
+		return newSelectAncestors(tag, false)
+	case "ancestor-or-self":
+		return newSelectAncestors(tag, true)
+	case "following-sibling":
+		return newSelectFollowingSiblings(tag)
+	case "preceding-sibling":
+		return newSelectPrecedingSiblings(tag)
+	case "following":
+		return newSelectFollowing(tag)
+	case "preceding":
+		return newSelectPreceding(tag)
+	}
+	return nil
+}
+
+// compileFilter lowers the expression of a path filter contained
+// within [brackets].
+func (c *compiler) compileFilter(x Expr) filter {
+	// Filter contains [N]?
+	if n, ok := x.(*NumberExpr); ok && n.Value == math.Trunc(n.Value) {
+		switch pos := int(n.Value); {
+		case pos > 0:
+			return newFilterPos(pos - 1)
+		case pos == 0:
+			return newFilterNone()
+		default:
+			return newFilterPos(pos)
+		}
+	}
+
+	if s, ok := x.(*SliceExpr); ok {
+		return newFilterSlice(s.Start, s.Stop, s.Step)
+	}
+
+	// A single condition, as [@attr], [@attr='text'], [tag] or [tag='text'],
+	// is a filter by itself.
+	e := condition(c.compileExpr(x))
+	if f, ok := e.(filter); ok {
+		return f
+	}
+	return newFilterExpr(e)
+}
+
+// condition returns the expression x to be evaluated as a boolean
+// condition. A bare @attr or tag is checked by the Element itself.
+func condition(x expr) expr {
+	switch x := x.(type) {
+	case *exprAttr:
+		return newFilterAttr(x.attr)
+	case *exprChild:
+		return newFilterChild(x.tag)
+	}
+	return x
+}
+
+// compileExpr lowers the expression of a filter.
+func (c *compiler) compileExpr(x Expr) expr {
+	switch x := x.(type) {
+	case *BinaryExpr:
+		switch x.Op {
+		case "or":
+			return &exprOr{condition(c.compileExpr(x.Left)), condition(c.compileExpr(x.Right))}
+		case "and":
+			return &exprAnd{condition(c.compileExpr(x.Left)), condition(c.compileExpr(x.Right))}
+		}
+		if x.Op == "~=" {
+			return c.compileMatch(x.Left, x.Right)
+		}
+		return c.compileCompare(x)
+	case *AttrExpr:
+		return &exprAttr{c.nameTest(x.Name)}
+	case *LiteralExpr:
+		return &exprLiteral{stringValue(x.Value)}
+	case *NumberExpr:
+		return &exprLiteral{numberValue(x.Value)}
+	case *VarExpr:
+		c.vars = append(c.vars, x.Name)
+		return &exprVar{x.Name}
+	case *CallExpr:
+		if x.Name == "matches" {
+			return c.compileMatch(x.Args[0], x.Args[1])
+		}
+		fn, _ := lookupFunc(x.Name, &c.opts)
+		args := make([]expr, len(x.Args))
+		for j, a := range x.Args {
+			if args[j] = c.compileExpr(a); fn.boolArgs {
+				args[j] = condition(args[j])
+			}
+		}
+		return &exprCall{fn, args}
+	case *PathExpr:
+		// A single tag or "*" is checked against the children.
+		if s := x.Steps; !x.Absolute && len(s) == 1 && s[0].Abbrev && s[0].Axis == "child" && len(s[0].Filters) == 0 {
+			return &exprChild{c.nameTest(s[0].Test)}
+		}
+		segments, read := c.compilePath(x)
+		return &exprPath{[][]segment{segments}, []valueRead{read}}
+	case *UnionExpr:
+		paths, reads := c.compileUnion(x)
+		return &exprPath{paths, reads}
+	}
+	return nil
+}
+
+// compileMatch lowers the match of the expression x with the regular
+// expression re, checked by the parser.
+func (c *compiler) compileMatch(x, re Expr) expr {
+	return &exprMatch{c.compileExpr(x), regexp.MustCompile(re.(*LiteralExpr).Value)}
+}
+
+// compileCompare lowers a comparison between two expressions.
+func (c *compiler) compileCompare(x *BinaryExpr) expr {
+	left, right := c.compileExpr(x.Left), c.compileExpr(x.Right)
+	lit, isLit := x.Right.(*LiteralExpr)
+
+	// Equality with a 'text' value is checked by the Element itself. If the
+	// value is a number, the element can also have an equal number written
+	// differently, as 2 for '2.0'.
+	cmp := newExprCompare(x.Op, left, right)
+	if x.Op != "=" || !isLit {
+		return cmp
+	}
+	var eq expr
+	switch l := left.(type) {
+	case *exprAttr:
+		eq = newFilterAttrText(l.attr, lit.Value)
+	case *exprChild:
+		eq = newFilterChildText(l.tag, lit.Value)
+	default:
+		return cmp
+	}
+	if _, isNum := parseNumber(lit.Value); isNum {
+		return &exprOr{eq, cmp}
+	}
+	return eq
+}
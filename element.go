@@ -0,0 +1,108 @@
+package treepath
+
+// The following interfaces can be optionally implemented by an Element,
+// to give the pather access to its values. They are discovered by type
+// assertion: features needing a value that the Element does not provide
+// simply find no match.
+
+// Tagger is an optional interface of an Element that gives access to
+// its tag. It is needed to match the tags with a glob pattern, as h*.
+type Tagger interface {
+	// Tag returns the tag of the current element.
+	Tag() string
+}
+
+// Attributer is an optional interface of an Element that gives access
+// to its attribute values. It is needed by filters comparing attribute
+// values, as [@priority>3], and by the [@*] and [@data-*] filters.
+type Attributer interface {
+	// Attr returns the value of the attribute with the given Name, and
+	// whether the attribute was found.
+	Attr(string) (string, bool)
+
+	// Attrs returns the names of the attributes of the current element.
+	Attrs() []string
+}
+
+// NamespacedElement is an optional interface of an Element whose tag and
+// attributes belong to namespaces. It is needed to match the prefixed
+// names of a path, as soap:Body or @xlink:href, by the namespace URI the
+// prefix is bound to in the Namespaces of the CompileOptions, and the
+// names with any namespace, as *:Body.
+type NamespacedElement interface {
+	// TagNS returns the namespace URI and the local name of the tag of
+	// the current element.
+	TagNS() Name
+
+	// AttrsNS returns the namespace URIs and the local names of the
+	// attributes of the current element.
+	AttrsNS() []Name
+
+	// AttrNS returns the value of the attribute with the given namespace
+	// URI and local name, and whether the attribute was found.
+	AttrNS(Name) (string, bool)
+}
+
+// Name is the namespace URI and the local name of a tag or attribute.
+// Space is empty for a name without a namespace.
+type Name struct {
+	Space, Local string
+}
+
+// Texter is an optional interface of an Element that gives access to its
+// text value. It is needed by filters comparing the text of child elements,
+// as [size>=10].
+type Texter interface {
+	// Text returns the text value of the current element.
+	Text() string
+}
+
+// attrValues returns the values of the attributes of e with a name
+// matching the name test: a single attribute for a plain name, any
+// attribute for "*" or the attributes matching a glob pattern.
+// A prefixed name is matched by namespace if e implements the
+// NamespacedElement interface. Otherwise, it returns nil if e does not
+// implement the Attributer interface.
+func attrValues(e Element, name nameTest) []string {
+	if n, ok := e.(NamespacedElement); ok && name.ns != nil {
+		var values []string
+		for _, attr := range n.AttrsNS() {
+			if !name.ns.match(attr) {
+				continue
+			}
+			if v, ok := n.AttrNS(attr); ok {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	a, ok := e.(Attributer)
+	if !ok {
+		return nil
+	}
+	if name.isPlain() {
+		if v, ok := a.Attr(name.name); ok {
+			return []string{v}
+		}
+		return nil
+	}
+	var values []string
+	for _, n := range a.Attrs() {
+		if !name.match(n) {
+			continue
+		}
+		if v, ok := a.Attr(n); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// textValue returns the text of e, and whether e implements
+// the Texter interface.
+func textValue(e Element) (string, bool) {
+	if t, ok := e.(Texter); ok {
+		return t.Text(), true
+	}
+	return "", false
+}
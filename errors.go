@@ -0,0 +1,86 @@
+package treepath
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrPath describes an invalid path. The *SyntaxError returned by
+// CompilePath can be matched as an ErrPath with errors.As.
+type ErrPath string
+
+// Error returns the string describing a path error.
+func (err ErrPath) Error() string {
+	return "treepath: " + string(err)
+}
+
+// An ErrorCode identifies the kind of a SyntaxError.
+// Error codes are stable, and can be used to localize the messages.
+type ErrorCode string
+
+// Error codes of the syntax errors.
+const (
+	ErrSyntax          ErrorCode = "syntax"
+	ErrEmptyUnion      ErrorCode = "empty-union"
+	ErrBrackets        ErrorCode = "brackets"
+	ErrEmptyFilter     ErrorCode = "empty-filter"
+	ErrQuotes          ErrorCode = "quotes"
+	ErrExpression      ErrorCode = "expression"
+	ErrUnknownAxis     ErrorCode = "unknown-axis"
+	ErrEmptyNodeTest   ErrorCode = "empty-node-test"
+	ErrUnknownFunction ErrorCode = "unknown-function"
+	ErrArguments       ErrorCode = "arguments"
+	ErrPattern         ErrorCode = "pattern"
+	ErrRegexp          ErrorCode = "regexp"
+	ErrSlice           ErrorCode = "slice"
+	ErrBuiltinFunction ErrorCode = "builtin-function"
+)
+
+// errorMessages contains the messages of the syntax errors, by code.
+var errorMessages = map[ErrorCode]string{
+	ErrSyntax:          "path has invalid syntax.",
+	ErrEmptyUnion:      "path has an empty union member.",
+	ErrBrackets:        "path has invalid filter [brackets].",
+	ErrEmptyFilter:     "path contains an empty filter expression.",
+	ErrQuotes:          "path has mismatched filter quotes.",
+	ErrExpression:      "path has invalid filter expression.",
+	ErrUnknownAxis:     "path has an unknown axis.",
+	ErrEmptyNodeTest:   "path has an empty axis node test.",
+	ErrUnknownFunction: "path has an unknown function.",
+	ErrArguments:       "path has a function with a wrong number of arguments.",
+	ErrPattern:         "path has an invalid name pattern.",
+	ErrRegexp:          "path has an invalid regular expression.",
+	ErrSlice:           "path has an invalid [start:stop:step] slice.",
+	ErrBuiltinFunction: "path calls a built-in function redefined by the options.",
+}
+
+// SyntaxError is returned by CompilePath when the path is invalid.
+// It describes the error and where it was found.
+type SyntaxError struct {
+	Code    ErrorCode // the kind of the error
+	Path    string    // the path being compiled
+	Offset  int       // the byte offset of the error within Path
+	Segment int       // the index of the "/" separated segment containing the error
+	Token   string    // the offending token, if any
+}
+
+// Error returns the string describing the syntax error.
+func (err *SyntaxError) Error() string {
+	return ErrPath(errorMessages[err.Code]).Error()
+}
+
+// Unwrap returns the error as an ErrPath, so that errors.As can match
+// a SyntaxError as an ErrPath too.
+func (err *SyntaxError) Unwrap() error {
+	return ErrPath(errorMessages[err.Code])
+}
+
+// Excerpt returns the path followed by a line with a caret marking
+// the position of the error, as in:
+//
+//	./html//p[@lang='en' or]
+//	                        ^
+func (err *SyntaxError) Excerpt() string {
+	col := utf8.RuneCountInString(err.Path[:err.Offset])
+	return err.Path + "\n" + strings.Repeat(" ", col) + "^"
+}
@@ -0,0 +1,31 @@
+package treepath
+
+// Eval evaluates an expression from the root element, and returns its
+// value. The expression has the syntax of a filter expression, as
+// count(//li), sum(//item/@price)>100 or concat(name(), ':', @id), and
+// it is evaluated with root as the context element.
+// If the expression is invalid, the returned error is a *SyntaxError.
+func Eval(expr string, root Element) (Value, error) {
+	return EvalWith(expr, root, CompileOptions{}, nil)
+}
+
+// EvalWith is like Eval, but it uses the given options, and it binds the
+// $variables of the expression to the given values. It returns a
+// *VarError if a variable is not bound.
+// The Order and the Limits of the options are not used.
+func EvalWith(expr string, root Element, opts CompileOptions, vars Vars) (Value, error) {
+	comp := compiler{opts: opts, path: expr}
+	x := comp.parseExpr()
+	if comp.err != nil {
+		return Value{}, comp.err
+	}
+	e := comp.compileExpr(x)
+	values, err := Path{vars: comp.vars}.bind(vars)
+	if err != nil {
+		return Value{}, err
+	}
+	p := newPather(Limits{})
+	p.vars = values
+	ctx := exprContext{e: root, pos: 1, size: 1, p: p}
+	return Value{e.eval(&ctx)}, nil
+}
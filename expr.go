@@ -0,0 +1,188 @@
+package treepath
+
+import "regexp"
+
+// An expr is an expression contained within [brackets].
+// It is evaluated against each element of the candidate list.
+type expr interface {
+	eval(ctx *exprContext) value
+}
+
+// An exprContext is the context an expr is evaluated in: the candidate
+// element, its 1-based position within the candidate list, the size
+// of the candidate list, and the pather evaluating the expression, with
+// the values of the variables.
+type exprContext struct {
+	e    Element
+	pos  int
+	size int
+	p    *pather
+}
+
+// ----------------------------------------------------------------------------
+
+// exprAnd is satisfied if both the sub-expressions are satisfied.
+type exprAnd struct {
+	left, right expr
+}
+
+func (x *exprAnd) eval(ctx *exprContext) value {
+	return boolValue(x.left.eval(ctx).toBool() && x.right.eval(ctx).toBool())
+}
+
+// ----------------------------------------------------------------------------
+
+// exprOr is satisfied if at least one of the sub-expressions is satisfied.
+type exprOr struct {
+	left, right expr
+}
+
+func (x *exprOr) eval(ctx *exprContext) value {
+	return boolValue(x.left.eval(ctx).toBool() || x.right.eval(ctx).toBool())
+}
+
+// ----------------------------------------------------------------------------
+
+// exprCompare is satisfied if the relation op holds between any string of
+// the left value and any string of the right value.
+// Strings are compared as numbers if both of them are numbers, as strings
+// otherwise.
+type exprCompare struct {
+	op          string
+	left, right expr
+}
+
+func newExprCompare(op string, left, right expr) *exprCompare {
+	return &exprCompare{op, left, right}
+}
+
+func (x *exprCompare) eval(ctx *exprContext) value {
+	lv, rv := x.left.eval(ctx), x.right.eval(ctx)
+	rstrs := rv.toStrings()
+	for _, l := range lv.toStrings() {
+		for _, r := range rstrs {
+			if compare(x.op, l, r) {
+				return boolValue(true)
+			}
+		}
+	}
+	return boolValue(false)
+}
+
+// ----------------------------------------------------------------------------
+
+// exprMatch returns true if a string of the value of x matches the
+// regular expression, compiled with the path, as in @id~='^v[0-9]+$'
+// and matches(name(), '^h[1-6]$').
+type exprMatch struct {
+	x  expr
+	re *regexp.Regexp
+}
+
+func (x *exprMatch) eval(ctx *exprContext) value {
+	for _, s := range x.x.eval(ctx).toStrings() {
+		if x.re.MatchString(s) {
+			return boolValue(true)
+		}
+	}
+	return boolValue(false)
+}
+
+// ----------------------------------------------------------------------------
+
+// exprAttr returns the value of an attribute of the element, or the
+// values of all its attributes matching attr if it is "*" or a pattern.
+// The element must implement the Attributer interface.
+type exprAttr struct {
+	attr nameTest
+}
+
+func (x *exprAttr) eval(ctx *exprContext) value {
+	return stringsValue(attrValues(ctx.e, x.attr))
+}
+
+// ----------------------------------------------------------------------------
+
+// exprChild returns the child elements of the element having the
+// specified tag.
+type exprChild struct {
+	tag nameTest
+}
+
+func (x *exprChild) eval(ctx *exprContext) value {
+	var nodes []Element
+	for _, c := range ctx.e.Children() {
+		if matchTag(c, x.tag) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodesValue(nodes)
+}
+
+// ----------------------------------------------------------------------------
+
+// exprPath returns the elements matched by the paths of a union,
+// evaluated from the element, as .//li or ../p[@class]. If a path
+// reads a value, as li/@priority, it returns the values instead.
+// The elements visited by the paths count toward the MaxVisited and
+// MaxQueue limits of the enclosing path, whose context stops them too.
+type exprPath struct {
+	paths [][]segment
+	reads []valueRead
+}
+
+func (x *exprPath) eval(ctx *exprContext) value {
+	limits := Limits{MaxVisited: ctx.p.limits.MaxVisited, MaxQueue: ctx.p.limits.MaxQueue}
+	path := Path{paths: x.paths, reads: x.reads, limits: limits}
+	for _, read := range x.reads {
+		if read.name != "" {
+			return stringsValue(readValues(ctx.e, path, ctx.p))
+		}
+	}
+	p := ctx.p.fork(limits)
+	results := p.traverse(ctx.e, path)
+	ctx.p.join(p)
+	return nodesValue(results)
+}
+
+// ----------------------------------------------------------------------------
+
+// exprVar returns the value bound to a variable, or an empty set
+// if the variable is not bound.
+type exprVar struct {
+	name string
+}
+
+func (x *exprVar) eval(ctx *exprContext) value {
+	if v, ok := ctx.p.vars[x.name]; ok {
+		return v
+	}
+	return stringsValue(nil)
+}
+
+// ----------------------------------------------------------------------------
+
+// exprLiteral returns a constant value, as a 'text' or a number.
+type exprLiteral struct {
+	v value
+}
+
+func (x *exprLiteral) eval(ctx *exprContext) value {
+	return x.v
+}
+
+// ----------------------------------------------------------------------------
+
+// exprCall returns the result of a function call.
+type exprCall struct {
+	fn   *function
+	args []expr
+}
+
+func (x *exprCall) eval(ctx *exprContext) value {
+	args := make([]value, len(x.args))
+	for j, a := range x.args {
+		args[j] = a.eval(ctx)
+	}
+	return x.fn.call(ctx, args)
+}
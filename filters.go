@@ -5,22 +5,52 @@ package treepath
 // filterAttrVal filters the candidate list for elements having
 // the specified attribute with the specified value.
 type filterAttrText struct {
-	attr, text string
+	attr nameTest
+	text string
 }
 
-func newFilterAttrText(attr, text string) *filterAttrText {
+func newFilterAttrText(attr nameTest, text string) *filterAttrText {
 	return &filterAttrText{attr, text}
 }
 
+func (f *filterAttrText) match(e Element) bool {
+	if fallbackAttr(e, f.attr) {
+		return e.MatchAttrText(f.attr.name, f.text)
+	}
+	for _, v := range attrValues(e, f.attr) {
+		if v == f.text {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *filterAttrText) eval(ctx *exprContext) value {
+	return boolValue(f.match(ctx.e))
+}
+
 func (f *filterAttrText) apply(p *pather) {
 	for _, c := range p.candidates {
-		if c.MatchAttrText(f.attr, f.text) {
+		if f.match(c) {
 			p.scratch = append(p.scratch, c)
 		}
 	}
 	p.candidates, p.scratch = p.scratch, p.candidates[0:0]
 }
 
+// fallbackAttr returns true if the attribute name test must be passed to
+// the MatchAttr methods of e: the name is plain or prefixed, or it is a
+// glob pattern and e does not implement the Attributer interface.
+// A prefixed name is matched by namespace if e implements the
+// NamespacedElement interface.
+func fallbackAttr(e Element, attr nameTest) bool {
+	if _, ok := e.(NamespacedElement); ok && attr.ns != nil {
+		return false
+	}
+	_, ok := e.(Attributer)
+	return attr.name != "*" && attr.glob == nil || attr.glob != nil && !ok
+}
+
 // ----------------------------------------------------------------------------
 
 // filterPos filters the candidate list, keeping only the
@@ -48,19 +78,105 @@ func (f *filterPos) apply(p *pather) {
 
 // ----------------------------------------------------------------------------
 
+// filterNone empties the candidate list. It is the [0] filter, since the
+// positions start from 1.
+type filterNone struct{}
+
+func newFilterNone() *filterNone {
+	return &filterNone{}
+}
+
+func (f *filterNone) apply(p *pather) {
+	p.candidates = p.candidates[0:0]
+}
+
+// ----------------------------------------------------------------------------
+
+// filterSlice filters the candidate list, keeping the candidates from
+// the start index to the stop index excluded, one every step, as the
+// slices of Python do. Indexes start from 0, and negative indexes count
+// from the end of the list. A negative step keeps the candidates backward.
+type filterSlice struct {
+	start, stop, step int
+	hasStart, hasStop bool
+}
+
+func newFilterSlice(start, stop, step *int) *filterSlice {
+	f := &filterSlice{step: 1}
+	if start != nil {
+		f.start, f.hasStart = *start, true
+	}
+	if stop != nil {
+		f.stop, f.hasStop = *stop, true
+	}
+	if step != nil {
+		f.step = *step
+	}
+	return f
+}
+
+// index returns the index i in a list of n items, clamped from lower to
+// upper, or def if the index is omitted.
+func (f *filterSlice) index(i int, has bool, n, lower, upper, def int) int {
+	switch {
+	case !has:
+		return def
+	case i < 0:
+		i += n
+	}
+	if i < lower {
+		return lower
+	}
+	if i > upper {
+		return upper
+	}
+	return i
+}
+
+func (f *filterSlice) apply(p *pather) {
+	n := len(p.candidates)
+	if f.step > 0 {
+		start := f.index(f.start, f.hasStart, n, 0, n, 0)
+		stop := f.index(f.stop, f.hasStop, n, 0, n, n)
+		for j := start; j < stop; j += f.step {
+			p.scratch = append(p.scratch, p.candidates[j])
+		}
+	} else {
+		start := f.index(f.start, f.hasStart, n, -1, n-1, n-1)
+		stop := f.index(f.stop, f.hasStop, n, -1, n-1, -1)
+		for j := start; j > stop; j += f.step {
+			p.scratch = append(p.scratch, p.candidates[j])
+		}
+	}
+	p.candidates, p.scratch = p.scratch, p.candidates[0:0]
+}
+
+// ----------------------------------------------------------------------------
+
 // filterAttr filters the candidate list for elements having
 // the specified attribute.
 type filterAttr struct {
-	attr string
+	attr nameTest
 }
 
-func newFilterAttr(attr string) *filterAttr {
+func newFilterAttr(attr nameTest) *filterAttr {
 	return &filterAttr{attr}
 }
 
+func (f *filterAttr) match(e Element) bool {
+	if fallbackAttr(e, f.attr) {
+		return e.MatchAttr(f.attr.name)
+	}
+	return len(attrValues(e, f.attr)) > 0
+}
+
+func (f *filterAttr) eval(ctx *exprContext) value {
+	return boolValue(f.match(ctx.e))
+}
+
 func (f *filterAttr) apply(p *pather) {
 	for _, c := range p.candidates {
-		if c.MatchAttr(f.attr) {
+		if f.match(c) {
 			p.scratch = append(p.scratch, c)
 		}
 	}
@@ -72,19 +188,30 @@ func (f *filterAttr) apply(p *pather) {
 // filterChild filters the candidate list for elements having
 // a child element with the specified tag.
 type filterChild struct {
-	tag string
+	tag nameTest
 }
 
-func newFilterChild(tag string) *filterChild {
+func newFilterChild(tag nameTest) *filterChild {
 	return &filterChild{tag}
 }
 
+func (f *filterChild) match(e Element) bool {
+	for _, c := range e.Children() {
+		if matchTag(c, f.tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *filterChild) eval(ctx *exprContext) value {
+	return boolValue(f.match(ctx.e))
+}
+
 func (f *filterChild) apply(p *pather) {
 	for _, c := range p.candidates {
-		for _, cc := range c.Children() {
-			if cc.MatchTag(f.tag) {
-				p.scratch = append(p.scratch, c)
-			}
+		if f.match(c) {
+			p.scratch = append(p.scratch, c)
 		}
 	}
 	p.candidates, p.scratch = p.scratch, p.candidates[0:0]
@@ -93,21 +220,71 @@ func (f *filterChild) apply(p *pather) {
 // ----------------------------------------------------------------------------
 
 // filterChildText filters the candidate list for elements having
-// a child element with the specified tag and text.
+// a child element with the specified tag and text. The text of the
+// children matching "*", a glob pattern or a namespace is compared by
+// the filter, the other ones by MatchTagText.
 type filterChildText struct {
-	tag, text string
+	tag  nameTest
+	text string
 }
 
-func newFilterChildText(tag, text string) *filterChildText {
+func newFilterChildText(tag nameTest, text string) *filterChildText {
 	return &filterChildText{tag, text}
 }
 
+func (f *filterChildText) match(e Element) bool {
+	for _, c := range e.Children() {
+		_, isTagger := c.(Tagger)
+		_, isNS := c.(NamespacedElement)
+		if !(f.tag.name == "*" || f.tag.ns != nil && isNS || f.tag.glob != nil && isTagger) {
+			if c.MatchTagText(f.tag.name, f.text) {
+				return true
+			}
+		} else if matchTag(c, f.tag) {
+			if text, _ := textValue(c); text == f.text {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *filterChildText) eval(ctx *exprContext) value {
+	return boolValue(f.match(ctx.e))
+}
+
 func (f *filterChildText) apply(p *pather) {
 	for _, c := range p.candidates {
-		for _, cc := range c.Children() {
-			if cc.MatchTagText(f.tag, f.text) {
-				p.scratch = append(p.scratch, c)
-			}
+		if f.match(c) {
+			p.scratch = append(p.scratch, c)
+		}
+	}
+	p.candidates, p.scratch = p.scratch, p.candidates[0:0]
+}
+
+// ----------------------------------------------------------------------------
+
+// filterExpr filters the candidate list for elements satisfying
+// an expression. If the expression returns a number, it is satisfied by
+// the candidate at that position, as in [last()].
+type filterExpr struct {
+	x expr
+}
+
+func newFilterExpr(x expr) *filterExpr {
+	return &filterExpr{x}
+}
+
+func (f *filterExpr) apply(p *pather) {
+	ctx := exprContext{size: len(p.candidates), p: p}
+	for j, c := range p.candidates {
+		if p.check(); p.err != nil {
+			break
+		}
+		ctx.e, ctx.pos = c, j+1
+		v := f.x.eval(&ctx)
+		if v.kind == NumberKind && v.num == float64(ctx.pos) || v.kind != NumberKind && v.toBool() {
+			p.scratch = append(p.scratch, c)
 		}
 	}
 	p.candidates, p.scratch = p.scratch, p.candidates[0:0]
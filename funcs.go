@@ -0,0 +1,250 @@
+package treepath
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// A function is a function that can be called within a filter expression.
+// It accepts from minArgs to maxArgs arguments, or any number of arguments
+// from minArgs if maxArgs is -1. If boolArgs is true, the
+// arguments are evaluated as boolean conditions.
+type function struct {
+	minArgs, maxArgs int
+	boolArgs         bool
+	call             func(ctx *exprContext, args []value) value
+}
+
+// functions contains the built-in functions, by name.
+var functions = map[string]*function{
+	"not":             {1, 1, true, fnNot},
+	"true":            {0, 0, false, fnTrue},
+	"false":           {0, 0, false, fnFalse},
+	"boolean":         {1, 1, true, fnBoolean},
+	"number":          {0, 1, false, fnNumber},
+	"string":          {0, 1, false, fnString},
+	"concat":          {2, -1, false, fnConcat},
+	"contains":        {2, 2, false, fnContains},
+	"starts-with":     {2, 2, false, fnStartsWith},
+	"ends-with":       {2, 2, false, fnEndsWith},
+	"string-length":   {0, 1, false, fnStringLength},
+	"normalize-space": {0, 1, false, fnNormalizeSpace},
+	"count":           {1, 1, false, fnCount},
+	"sum":             {1, 1, false, fnSum},
+	"position":        {0, 0, false, fnPosition},
+	"last":            {0, 0, false, fnLast},
+	"text":            {0, 0, false, fnText},
+	"name":            {0, 1, false, fnName},
+	"local-name":      {0, 1, false, fnLocalName},
+	"matches":         {2, 2, false, nil}, // compiled as an exprMatch
+}
+
+// argOrContext returns the first argument, or the context element
+// if there are no arguments.
+func argOrContext(ctx *exprContext, args []value) value {
+	if len(args) == 0 {
+		return nodesValue([]Element{ctx.e})
+	}
+	return args[0]
+}
+
+// fnNot returns true if its argument is false.
+func fnNot(ctx *exprContext, args []value) value {
+	return boolValue(!args[0].toBool())
+}
+
+// fnTrue returns true.
+func fnTrue(ctx *exprContext, args []value) value {
+	return boolValue(true)
+}
+
+// fnFalse returns false.
+func fnFalse(ctx *exprContext, args []value) value {
+	return boolValue(false)
+}
+
+// fnBoolean converts its argument to a boolean.
+func fnBoolean(ctx *exprContext, args []value) value {
+	return boolValue(args[0].toBool())
+}
+
+// fnNumber converts its argument, or the text of the context element,
+// to a number.
+func fnNumber(ctx *exprContext, args []value) value {
+	return numberValue(argOrContext(ctx, args).toNumber())
+}
+
+// fnString converts its argument, or the text of the context element,
+// to a string.
+func fnString(ctx *exprContext, args []value) value {
+	return stringValue(argOrContext(ctx, args).toString())
+}
+
+// fnConcat returns the concatenation of the strings of its arguments.
+func fnConcat(ctx *exprContext, args []value) value {
+	var b strings.Builder
+	for _, a := range args {
+		b.WriteString(a.toString())
+	}
+	return stringValue(b.String())
+}
+
+// fnContains returns true if the first argument contains the second one.
+func fnContains(ctx *exprContext, args []value) value {
+	return boolValue(strings.Contains(args[0].toString(), args[1].toString()))
+}
+
+// fnStartsWith returns true if the first argument starts with the second one.
+func fnStartsWith(ctx *exprContext, args []value) value {
+	return boolValue(strings.HasPrefix(args[0].toString(), args[1].toString()))
+}
+
+// fnEndsWith returns true if the first argument ends with the second one.
+func fnEndsWith(ctx *exprContext, args []value) value {
+	return boolValue(strings.HasSuffix(args[0].toString(), args[1].toString()))
+}
+
+// fnStringLength returns the number of characters of its argument, or of
+// the text of the context element.
+func fnStringLength(ctx *exprContext, args []value) value {
+	return numberValue(float64(utf8.RuneCountInString(argOrContext(ctx, args).toString())))
+}
+
+// fnNormalizeSpace returns its argument, or the text of the context element,
+// with leading and trailing spaces removed and the other sequences of
+// spaces replaced by a single space.
+func fnNormalizeSpace(ctx *exprContext, args []value) value {
+	return stringValue(strings.Join(strings.Fields(argOrContext(ctx, args).toString()), " "))
+}
+
+// fnCount returns the number of items of its argument.
+func fnCount(ctx *exprContext, args []value) value {
+	return numberValue(float64(args[0].count()))
+}
+
+// fnSum returns the sum of the numbers of the strings of its argument.
+// It returns NaN if a string is not a number.
+func fnSum(ctx *exprContext, args []value) value {
+	sum := 0.0
+	for _, s := range args[0].toStrings() {
+		sum += stringValue(s).toNumber()
+	}
+	return numberValue(sum)
+}
+
+// fnPosition returns the position of the context element within
+// the candidate list.
+func fnPosition(ctx *exprContext, args []value) value {
+	return numberValue(float64(ctx.pos))
+}
+
+// fnLast returns the size of the candidate list.
+func fnLast(ctx *exprContext, args []value) value {
+	return numberValue(float64(ctx.size))
+}
+
+// fnText returns the text of the context element. The result is empty
+// if the element has no text or does not implement the Texter interface.
+func fnText(ctx *exprContext, args []value) value {
+	if t, ok := textValue(ctx.e); ok && t != "" {
+		return stringsValue([]string{t})
+	}
+	return stringsValue(nil)
+}
+
+// fnName returns the tag of the first element of its argument, or of
+// the context element. The element must implement the Tagger interface.
+func fnName(ctx *exprContext, args []value) value {
+	v := argOrContext(ctx, args)
+	if v.kind == ElementsKind && len(v.nodes) > 0 {
+		if t, ok := v.nodes[0].(Tagger); ok {
+			return stringValue(t.Tag())
+		}
+	}
+	return stringValue("")
+}
+
+// fnLocalName returns the tag of the first element of its argument, or of
+// the context element, without the namespace prefix.
+func fnLocalName(ctx *exprContext, args []value) value {
+	v := argOrContext(ctx, args)
+	if v.kind == ElementsKind && len(v.nodes) > 0 {
+		if n, ok := v.nodes[0].(NamespacedElement); ok {
+			return stringValue(n.TagNS().Local)
+		}
+	}
+	_, local := spaceDecompose(fnName(ctx, args).str)
+	return stringValue(local)
+}
+
+// ----------------------------------------------------------------------------
+
+// A Func is a custom function that can be called within the filter
+// expressions of a path, as in [semver-gte(@version, '1.2.0')].
+// It is called with the element being filtered and the string values of
+// the arguments, and it must return a bool, a string, an int or a float64.
+// Any other result is taken as false.
+type Func func(e Element, args []string) interface{}
+
+var (
+	funcsMu sync.RWMutex
+	funcs   = make(map[string]Func)
+)
+
+// RegisterFunc makes a custom function available to all the paths
+// compiled afterward, under the given name. A function registered
+// with the same name is replaced.
+// It panics if fn is nil, or if name is the name of a built-in function.
+func RegisterFunc(name string, fn Func) {
+	if fn == nil {
+		panic("treepath: RegisterFunc function is nil")
+	}
+	if _, ok := functions[name]; ok {
+		panic("treepath: RegisterFunc called for built-in function " + name)
+	}
+	funcsMu.Lock()
+	defer funcsMu.Unlock()
+	funcs[name] = fn
+}
+
+// lookupFunc returns the function with the given name: a built-in function,
+// a custom function of the compile options or a registered one, in this order.
+// The parser rejects the calls of the built-in functions redefined by the
+// compile options.
+func lookupFunc(name string, opts *CompileOptions) (*function, bool) {
+	if fn, ok := functions[name]; ok {
+		return fn, true
+	}
+	fn, ok := opts.Funcs[name]
+	if !ok {
+		funcsMu.RLock()
+		fn, ok = funcs[name]
+		funcsMu.RUnlock()
+	}
+	if !ok {
+		return nil, false
+	}
+	return &function{0, -1, false, customCall(fn)}, true
+}
+
+// customCall returns the call of a function evaluating the custom function fn.
+func customCall(fn Func) func(ctx *exprContext, args []value) value {
+	return func(ctx *exprContext, args []value) value {
+		strs := make([]string, len(args))
+		for j, a := range args {
+			strs[j] = a.toString()
+		}
+		switch r := fn(ctx.e, strs).(type) {
+		case bool:
+			return boolValue(r)
+		case string:
+			return stringValue(r)
+		case int:
+			return numberValue(float64(r))
+		case float64:
+			return numberValue(r)
+		}
+		return boolValue(false)
+	}
+}
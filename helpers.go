@@ -1,34 +1,111 @@
 package treepath
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 // spaceDecompose breaks a namespace:tag identifier at the ':'
 // and returns the two parts.
-//func spaceDecompose(str string) (space, key string) {
-//colon := strings.IndexByte(str, ':')
-//if colon == -1 {
-//return "", str
-//}
-//return str[:colon], str[colon+1:]
-//}
+func spaceDecompose(str string) (space, key string) {
+	colon := strings.IndexByte(str, ':')
+	if colon == -1 {
+		return "", str
+	}
+	return str[:colon], str[colon+1:]
+}
 
-// nextIndex returns the index of the next occurrence of sep in s,
-// starting from offset.  It returns -1 if the sep string is not found.
-func nextIndex(s, sep string, offset int) int {
-	switch i := strings.Index(s[offset:], sep); i {
-	case -1:
-		return -1
-	default:
-		return offset + i
+// matchTag returns true if the element e has a tag matching the name test.
+// A prefixed name is matched against the namespace and the local name of
+// an element implementing the NamespacedElement interface. A glob pattern
+// is matched against the tag of an element implementing the Tagger
+// interface. Otherwise, the name is passed to MatchTag.
+func matchTag(e Element, tag nameTest) bool {
+	n, isNS := e.(NamespacedElement)
+	switch {
+	case tag.name == "*":
+		return true
+	case tag.ns != nil && isNS:
+		return tag.ns.match(n.TagNS())
+	case tag.glob != nil:
+		if t, ok := e.(Tagger); ok {
+			return tag.glob.MatchString(t.Tag())
+		}
+	}
+	return e.MatchTag(tag.name)
+}
+
+// siblings returns the children of the parent of e, and the index of e
+// among them. It returns nil and -1 if e has no parent.
+// Elements are compared by equality, so e must be comparable with its
+// parent's children.
+func siblings(e Element) ([]Element, int) {
+	parent := e.Parent()
+	if parent == nil {
+		return nil, -1
+	}
+	children := parent.Children()
+	for j, c := range children {
+		if c == e {
+			return children, j
+		}
 	}
+	return nil, -1
 }
 
-// isInteger returns true if the string s contains an integer.
-func isInteger(s string) bool {
+// parseNumber returns the value of s if it is a decimal number,
+// as -12 or 3.5, ignoring the surrounding spaces.
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	digits := 0
 	for i := 0; i < len(s); i++ {
-		if (s[i] < '0' || s[i] > '9') && !(i == 0 && s[i] == '-') {
-			return false
+		switch {
+		case isDigit(s[i]):
+			digits++
+		case (s[i] == '-' || s[i] == '+') && i == 0:
+		case s[i] == '.':
+		default:
+			return 0, false
+		}
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// compare returns true if the relation op holds between a and b.
+// Values are compared as numbers if both of them are numbers, as strings
+// otherwise.
+func compare(op, a, b string) bool {
+	var cmp int
+	x, xok := parseNumber(a)
+	y, yok := parseNumber(b)
+	switch {
+	case xok && yok:
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
 		}
+	default:
+		cmp = strings.Compare(a, b)
+	}
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
 	}
-	return true
+	return false
 }
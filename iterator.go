@@ -0,0 +1,66 @@
+package treepath
+
+// An Iterator iterates over the elements matched by a path.
+// For Unordered paths, the elements are found lazily: the tree is traversed
+// only as far as needed to return the next element, and the elements already
+// returned are not kept, unless the path can match an element twice, as
+// a union or //div//p. For the other orders, all the matching elements are
+// found and sorted by the first call to Next.
+type Iterator struct {
+	p     *pather
+	root  Element
+	paths [][]segment // the paths of the union still to be traversed
+	order Order
+	next  int // the index of the next result to return
+}
+
+// Iter returns an Iterator over the elements that matched the path,
+// evaluated from the root Element as by FindElements, with the $variables
+// evaluated as empty sets of attributes.
+func (path Path) Iter(root Element) *Iterator {
+	p := newPather(path.limits)
+	p.unique = path.unique()
+	return &Iterator{
+		p:     p,
+		root:  root,
+		paths: path.paths,
+		order: path.order,
+	}
+}
+
+// Next returns the next element matched by the path, and whether there
+// was one.
+func (it *Iterator) Next() (Element, bool) {
+	p := it.p
+	if it.order != Unordered && it.paths != nil {
+		p.traverse(it.root, Path{paths: it.paths})
+		sortElements(p.results, it.order)
+		it.paths = nil
+	}
+
+	// Evaluate the queue nodes until a new result is found
+	for it.next >= len(p.results) {
+		if p.err != nil {
+			return nil, false
+		}
+		if p.queue.Len() == 0 {
+			if len(it.paths) == 0 {
+				return nil, false
+			}
+			p.queue.Push(&node{it.root, it.paths[0]})
+			it.paths = it.paths[1:]
+		}
+		// The results already returned are no longer needed
+		p.results, it.next = p.results[0:0], 0
+		p.eval(p.queue.Pop())
+	}
+
+	e := p.results[it.next]
+	it.next++
+	return e, true
+}
+
+// Err returns the *LimitError that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.p.err
+}
@@ -0,0 +1,158 @@
+package treepath
+
+// A tokenKind identifies the type of a token of a path.
+type tokenKind int
+
+const (
+	tokEOF        tokenKind = iota
+	tokInvalid              // a character that cannot start a token
+	tokName                 // a tag, attribute, axis or function name, or a glob pattern
+	tokString               // a 'text' or "text" literal
+	tokNumber               // a decimal number, as 3 or -1.5
+	tokVar                  // a $variable
+	tokAt                   // @
+	tokStar                 // *
+	tokDot                  // .
+	tokDotDot               // ..
+	tokSlash                // /
+	tokSlashSlash           // //
+	tokColonColon           // ::
+	tokColon                // :
+	tokPipe                 // |
+	tokLBracket             // [
+	tokRBracket             // ]
+	tokLParen               // (
+	tokRParen               // )
+	tokComma                // ,
+	tokOp                   // = != < <= > >= ~=
+)
+
+// A token is a lexical item of a path.
+type token struct {
+	kind tokenKind
+	text string // the text of the token, as written in the path
+	val  string // the value of a string literal, or the name of a variable
+	pos  int    // byte offset of the token within the path
+}
+
+// punctuation contains the tokens made of punctuation characters,
+// the longest ones first.
+var punctuation = []struct {
+	text string
+	kind tokenKind
+}{
+	{"..", tokDotDot},
+	{"//", tokSlashSlash},
+	{"::", tokColonColon},
+	{"!=", tokOp},
+	{"~=", tokOp},
+	{"<=", tokOp},
+	{">=", tokOp},
+	{":", tokColon},
+	{"@", tokAt},
+	{"*", tokStar},
+	{".", tokDot},
+	{"/", tokSlash},
+	{"|", tokPipe},
+	{"[", tokLBracket},
+	{"]", tokRBracket},
+	{"(", tokLParen},
+	{")", tokRParen},
+	{",", tokComma},
+	{"=", tokOp},
+	{"<", tokOp},
+	{">", tokOp},
+}
+
+// tokenize splits a path in tokens. The returned slice is always
+// terminated by a tokEOF token. A character that cannot start a token,
+// or a text value missing its closing quote, is returned as a tokInvalid
+// token, which is reported by the parser.
+//
+// Text values are enclosed in 'single' or "double" quotes. Within them,
+// a backslash escapes a quote or another backslash, and it is taken
+// literally before any other character.
+func tokenize(s string) []token {
+	toks := make([]token, 0)
+next:
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+			continue
+		case ch == '\'' || ch == '"':
+			val := make([]byte, 0)
+			for j := i + 1; j < len(s); j++ {
+				switch {
+				case s[j] == ch:
+					toks = append(toks, token{tokString, s[i : j+1], string(val), i})
+					i = j + 1
+					continue next
+				case s[j] == '\\' && j+1 < len(s) && isEscaped(s[j+1]):
+					j++
+				}
+				val = append(val, s[j])
+			}
+			toks = append(toks, token{tokInvalid, s[i:], "", i})
+			break next
+		case isDigit(ch) || (ch == '-' && i+1 < len(s) && isDigit(s[i+1])):
+			j := i + 1
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			if j+1 < len(s) && s[j] == '.' && isDigit(s[j+1]) {
+				for j++; j < len(s) && isDigit(s[j]); j++ {
+				}
+			}
+			toks = append(toks, token{tokNumber, s[i:j], "", i})
+			i = j
+			continue
+		case ch == '$' && i+1 < len(s) && isNameStart(s[i+1]):
+			j := i + 2
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			toks = append(toks, token{tokVar, s[i:j], s[i+1 : j], i})
+			i = j
+			continue
+		case isNameStart(ch) || ch == '?' || ch == '*' && nameEnd(s, i) > i+1:
+			j := nameEnd(s, i)
+			toks = append(toks, token{tokName, s[i:j], "", i})
+			i = j
+			continue
+		}
+		for _, p := range punctuation {
+			if len(s)-i >= len(p.text) && s[i:i+len(p.text)] == p.text {
+				toks = append(toks, token{p.kind, p.text, "", i})
+				i += len(p.text)
+				continue next
+			}
+		}
+		toks = append(toks, token{tokInvalid, s[i : i+1], "", i})
+		i++
+	}
+	return append(toks, token{tokEOF, "", "", len(s)})
+}
+
+// isEscaped returns true if ch can be escaped by a backslash
+// within a text value.
+func isEscaped(ch byte) bool {
+	return ch == '\'' || ch == '"' || ch == '\\'
+}
+
+// isDigit returns true if ch is a decimal digit.
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// isNameStart returns true if ch can be the first character of a tag,
+// attribute, axis or function name.
+func isNameStart(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || ch >= 0x80
+}
+
+// isNameChar returns true if ch can be part of a tag or attribute name.
+func isNameChar(ch byte) bool {
+	return isNameStart(ch) || isDigit(ch) || ch == '-' || ch == '.' || ch == ':'
+}
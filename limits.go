@@ -0,0 +1,60 @@
+package treepath
+
+import "fmt"
+
+// Limits bounds the resources used to evaluate a path.
+// A zero value means no limit.
+type Limits struct {
+	// MaxVisited is the maximum number of elements selected by the
+	// segments of the path, before filtering, including the segments
+	// of the paths nested in its filters, as count(.//li).
+	MaxVisited int
+
+	// MaxQueue is the maximum number of elements waiting to be evaluated
+	// against the remaining segments of the path.
+	MaxQueue int
+
+	// MaxResults is the maximum number of elements matched by the path.
+	MaxResults int
+}
+
+// LimitError is returned, together with the partial results, when the
+// evaluation of a path exceeds one of its Limits.
+type LimitError struct {
+	Limit string // the exceeded limit: "visited", "queue" or "results"
+	Max   int    // the value of the exceeded limit
+}
+
+// Error returns the string describing a limit error.
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("treepath: path exceeded the %s limit of %d elements.", err.Limit, err.Max)
+}
+
+// check stops the pather, setting its error, if its context is done
+// or its limits are exceeded.
+func (p *pather) check() {
+	switch {
+	case p.err != nil:
+	case p.limits.MaxVisited > 0 && p.visited > p.limits.MaxVisited:
+		p.err = &LimitError{"visited", p.limits.MaxVisited}
+	case p.limits.MaxQueue > 0 && p.queue.Len() > p.limits.MaxQueue:
+		p.err = &LimitError{"queue", p.limits.MaxQueue}
+	case p.ctx != nil:
+		select {
+		case <-p.ctx.Done():
+			p.err = p.ctx.Err()
+		default:
+		}
+	}
+}
+
+// stopped checks the pather while a selector walks the tree, counting as
+// visited the candidates selected so far, and returns true if the
+// traversal must stop.
+func (p *pather) stopped() bool {
+	if p.err == nil && p.limits.MaxVisited > 0 && p.visited+len(p.candidates) > p.limits.MaxVisited {
+		p.err = &LimitError{"visited", p.limits.MaxVisited}
+	}
+	p.check()
+	return p.err != nil
+}
@@ -0,0 +1,165 @@
+package treepath
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A nameTest matches the tag of an element, or the name of an attribute.
+// The name can be "*" to match any name, or a glob pattern, as h* or
+// data-*, compiled when the path is compiled.
+//
+// A prefixed name, as soap:Body, is matched in the namespace of its prefix
+// if the element implements the NamespacedElement interface, and as it is
+// written otherwise.
+type nameTest struct {
+	name string
+	glob *regexp.Regexp // the compiled pattern, or nil if name is not a pattern
+	ns   *nsTest        // the namespace of a prefixed name, or nil
+}
+
+// newNameTest returns the name test of name. The prefix of the name is
+// resolved with the namespaces, by prefix: the "*" prefix is any
+// namespace, and an unknown prefix is part of the name.
+func newNameTest(name string, namespaces map[string]string) nameTest {
+	t := nameTest{name: name}
+	if name != "*" && isGlob(name) {
+		t.glob, _ = compileGlob(name)
+	}
+	prefix, local := spaceDecompose(name)
+	space, ok := namespaces[prefix]
+	if prefix == "*" {
+		space, ok = "*", true
+	}
+	if ok && prefix != "" && local != "" {
+		t.ns = &nsTest{space, newNameTest(local, nil)}
+	}
+	return t
+}
+
+// isPlain returns true if the name test is neither "*", a pattern
+// nor a name in a namespace.
+func (t nameTest) isPlain() bool {
+	return t.name != "*" && t.glob == nil && t.ns == nil
+}
+
+// match returns true if the name test matches the name.
+func (t nameTest) match(name string) bool {
+	if t.glob != nil {
+		return t.glob.MatchString(name)
+	}
+	return t.name == "*" || t.name == name
+}
+
+// An nsTest matches a namespace URI and a local name.
+type nsTest struct {
+	space string   // the namespace URI, or "*" for any namespace
+	local nameTest // the local name, "*" or a pattern
+}
+
+// match returns true if the nsTest matches the namespace URI and the
+// local name of a tag or attribute.
+func (t *nsTest) match(name Name) bool {
+	return (t.space == "*" || t.space == name.Space) && t.local.match(name.Local)
+}
+
+// isGlob returns true if the name is a glob pattern.
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// compileGlob compiles a glob pattern in a regexp matching the whole name.
+// In the pattern, "*" matches any sequence of characters, "?" matches any
+// single character, and a [class] matches a character in the class, as
+// [a-z], or not in the class if it starts with "!" or "^", as [!0-9].
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				end = len(pattern) - i
+			}
+			class := pattern[i+1 : i+end]
+			if class != "" && (class[0] == '!' || class[0] == '^') {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString(")$")
+	return regexp.Compile(b.String())
+}
+
+// nameEnd returns the end of the name or of the glob pattern starting
+// at the offset i of s.
+func nameEnd(s string, i int) int {
+	for i < len(s) {
+		switch ch := s[i]; {
+		case ch == ':' && i+1 < len(s) && s[i+1] == ':':
+			return i
+		case isNameChar(ch) || ch == '*' || ch == '?':
+			i++
+		case ch == '[' && classLen(s[i:]) > 0:
+			i += classLen(s[i:])
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// classLen returns the length of the [class] of a glob pattern at the
+// start of s, or 0 if there is none. Not to be taken for a filter, a
+// class must contain only ranges, as [a-z0-9], or be negated, as [!xy].
+func classLen(s string) int {
+	end := strings.IndexByte(s, ']')
+	if end < 2 {
+		return 0
+	}
+	class := s[1:end]
+	if class[0] == '!' || class[0] == '^' {
+		for j := 1; j < len(class); j++ {
+			if !isNameChar(class[j]) {
+				return 0
+			}
+		}
+		if len(class) == 1 {
+			return 0
+		}
+		return end + 1
+	}
+	if len(class)%3 != 0 {
+		return 0
+	}
+	for j := 0; j < len(class); j += 3 {
+		if class[j+1] != '-' || !isRangeChar(class[j]) || !isRangeChar(class[j+2]) {
+			return 0
+		}
+	}
+	return end + 1
+}
+
+// isRangeChar returns true if ch can be the bound of a range of a class,
+// a letter or a digit: [:-1] is a slice, not a class.
+func isRangeChar(ch byte) bool {
+	return isNameStart(ch) || isDigit(ch)
+}
+
+// isPattern returns true if s is a tag or attribute name, "*",
+// or a glob pattern. The pattern is not compiled.
+func isPattern(s string) bool {
+	if s == "" || !isNameStart(s[0]) && s[0] != '*' && s[0] != '?' {
+		return false
+	}
+	return nameEnd(s, 0) == len(s)
+}
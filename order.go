@@ -0,0 +1,136 @@
+package treepath
+
+import "sort"
+
+// Order is the order of the elements returned by a Path.
+type Order int
+
+const (
+	// Unordered returns the elements in the order they are found by the
+	// traversal of the path, without sorting them. It is the default.
+	Unordered Order = iota
+
+	// DocumentOrder returns the elements in the order of a pre-order
+	// depth-first visit of the tree: each element comes before its
+	// children, and after its preceding siblings and their descendants.
+	DocumentOrder
+
+	// ReverseDocumentOrder returns the elements in the reverse of
+	// DocumentOrder.
+	ReverseDocumentOrder
+
+	// BreadthFirstOrder returns the elements in the order of a
+	// breadth-first visit of the tree: by depth, and in document order
+	// within the same depth.
+	BreadthFirstOrder
+)
+
+// sortElements sorts the elements according to the order.
+func sortElements(elements []Element, order Order) {
+	if order == Unordered || len(elements) < 2 {
+		return
+	}
+	pr := newPositioner()
+	keys := make([][]int, len(elements))
+	for j, e := range elements {
+		keys[j] = pr.position(e)
+	}
+	sort.Sort(&elementSorter{elements, keys, order})
+}
+
+// A positioner computes the positions of elements in the tree. It caches
+// the positions already computed, and the index of each child among its
+// siblings, so that the children of a parent are fetched and scanned once.
+type positioner struct {
+	positions map[Element][]int
+	indexes   map[Element]int  // the index of each child of the parents
+	parents   map[Element]bool // the parents whose children are indexed
+}
+
+func newPositioner() *positioner {
+	return &positioner{
+		positions: make(map[Element][]int),
+		indexes:   make(map[Element]int),
+		parents:   make(map[Element]bool),
+	}
+}
+
+// position returns the position of e in the tree, as the indexes of its
+// ancestors and of itself among their siblings, starting from the root.
+func (pr *positioner) position(e Element) []int {
+	if pos, ok := pr.positions[e]; ok {
+		return pos
+	}
+	var pos []int
+	if parent := e.Parent(); parent != nil {
+		ppos := pr.position(parent)
+		pos = append(make([]int, 0, len(ppos)+1), ppos...)
+		pos = append(pos, pr.index(e, parent))
+	}
+	pr.positions[e] = pos
+	return pos
+}
+
+// index returns the index of e among the children of its parent, or -1
+// if it is not found, indexing the children of parent on the first call.
+func (pr *positioner) index(e, parent Element) int {
+	if !pr.parents[parent] {
+		pr.parents[parent] = true
+		for j, c := range parent.Children() {
+			pr.indexes[c] = j
+		}
+	}
+	if j, ok := pr.indexes[e]; ok {
+		return j
+	}
+	return -1
+}
+
+// An elementSorter sorts elements by their position in the tree.
+type elementSorter struct {
+	elements []Element
+	keys     [][]int
+	order    Order
+}
+
+func (s *elementSorter) Len() int {
+	return len(s.elements)
+}
+
+func (s *elementSorter) Swap(i, j int) {
+	s.elements[i], s.elements[j] = s.elements[j], s.elements[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s *elementSorter) Less(i, j int) bool {
+	a, b := s.keys[i], s.keys[j]
+	switch s.order {
+	case ReverseDocumentOrder:
+		return comparePositions(a, b) > 0
+	case BreadthFirstOrder:
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+	}
+	return comparePositions(a, b) < 0
+}
+
+// comparePositions compares two positions in document order. It returns
+// -1 if a comes before b, 1 if a comes after b and 0 if they are equal.
+func comparePositions(a, b []int) int {
+	for j := 0; j < len(a) && j < len(b); j++ {
+		switch {
+		case a[j] < b[j]:
+			return -1
+		case a[j] > b[j]:
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
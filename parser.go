@@ -0,0 +1,464 @@
+package treepath
+
+import (
+	"math"
+	"regexp"
+)
+
+// A compiler generates a compiled path from a path string. The string is
+// split in tokens, parsed by recursive descent in a syntax tree, and the
+// tree is lowered to the segments evaluated by the pather.
+//
+// The grammar of a path is:
+//
+//	union     = path { "|" path }
+//	path      = [ "/" | "//" ] step { ( "/" | "//" ) step } [ value ] | "/"
+//	step      = ( "." | ".." | "*" | tag | axis "::" ( tag | "*" ) ) { filter }
+//	value     = ( "/" | "//" ) ( "@" ( attr | "*" ) | "attribute::" ( attr | "*" ) | "text()" )
+//	filter    = "[" ( or | slice ) "]"
+//	slice     = [ number ] ":" [ number ] [ ":" [ number ] ]
+//	or        = and { "or" and }
+//	and       = condition { "and" condition }
+//	condition = primary [ op primary | "~=" text ]
+//	primary   = "(" or ")" | function "(" [ or { "," or } ] ")" |
+//	            "@" ( attr | "*" ) | text | number | "$" var | union
+//
+// The second argument of matches(), as the right operand of "~=", is a
+// regular expression: it must be a text, compiled with the path.
+//
+// A tag or attr can be a glob pattern, as h*, data-* or h[1-6], where "*"
+// matches any sequence of characters and "?" a single character. A [class]
+// of a pattern contains only ranges, or starts with "!" or "^" to negate
+// it, as [!0-9]: any other [brackets] following a name are a filter.
+// A tag or attr can have a namespace prefix, as soap:Body or *:Body.
+type compiler struct {
+	opts  CompileOptions
+	err   *SyntaxError
+	path  string   // the path being compiled
+	toks  []token  // the tokens not parsed yet
+	prev  token    // the last parsed token
+	seg   int      // index of the segment being parsed
+	depth int      // nesting level of the filter being parsed
+	vars  []string // the variables referenced by the path
+}
+
+// fail sets the compiler error at the token t, unless it is already set.
+func (c *compiler) fail(code ErrorCode, t token) {
+	if c.err == nil {
+		c.err = &SyntaxError{code, c.path, t.pos, c.seg, t.text}
+	}
+}
+
+// unexpected returns the code of the error reported for the
+// unexpected token t.
+func (c *compiler) unexpected(t token) ErrorCode {
+	switch {
+	case t.kind == tokInvalid && (t.text[0] == '\'' || t.text[0] == '"'):
+		return ErrQuotes
+	case t.kind == tokLBracket, t.kind == tokEOF && c.depth > 0:
+		return ErrBrackets
+	case c.depth > 0:
+		return ErrExpression
+	case t.kind == tokRBracket, c.prev.kind == tokRBracket:
+		return ErrBrackets
+	}
+	return ErrSyntax
+}
+
+// peek returns the next token, without consuming it.
+func (c *compiler) peek() token {
+	return c.toks[0]
+}
+
+// next consumes and returns the next token.
+func (c *compiler) next() token {
+	t := c.toks[0]
+	if t.kind != tokEOF {
+		c.toks = c.toks[1:]
+	}
+	c.prev = t
+	return t
+}
+
+// expect consumes the next token, and sets an error if it is not
+// of the given kind.
+func (c *compiler) expect(kind tokenKind) token {
+	t := c.peek()
+	if t.kind != kind {
+		c.fail(c.unexpected(t), t)
+		return t
+	}
+	return c.next()
+}
+
+// parse parses the path string in a syntax tree.
+func (c *compiler) parse() *UnionExpr {
+	c.toks = tokenize(c.path)
+	u := c.parseUnion()
+	if c.end(); c.err != nil {
+		return nil
+	}
+	return u
+}
+
+// parseExpr parses the string as an expression, as count(//li)>1,
+// in a syntax tree.
+func (c *compiler) parseExpr() Expr {
+	c.toks = tokenize(c.path)
+	x := c.parseOr()
+	if c.end(); c.err != nil {
+		return nil
+	}
+	return x
+}
+
+// end sets an error if the whole string has not been parsed.
+func (c *compiler) end() {
+	if t := c.peek(); t.kind != tokEOF {
+		c.fail(c.unexpected(t), t)
+	}
+}
+
+// parseUnion parses one or more paths separated by "|".
+func (c *compiler) parseUnion() *UnionExpr {
+	u := &UnionExpr{}
+	for c.err == nil {
+		if c.depth == 0 {
+			c.seg = 0
+		}
+		if t := c.peek(); t.kind == tokPipe || t.kind == tokEOF && len(u.Paths) > 0 {
+			c.fail(ErrEmptyUnion, t)
+			break
+		}
+		u.Paths = append(u.Paths, c.parsePath())
+		if c.peek().kind != tokPipe {
+			break
+		}
+		c.next()
+	}
+	return u
+}
+
+// parsePath parses a path made of steps separated by "/" or "//".
+// A trailing "//" selects all the descendants, as "//*".
+// The last step can take the @attr or the text() of the elements.
+func (c *compiler) parsePath() *PathExpr {
+	p := &PathExpr{}
+	sep := c.peek()
+	if sep.kind == tokSlash {
+		p.Absolute = true
+		c.next()
+		c.nextSegment(1)
+		if !c.atStep() {
+			return p
+		}
+	}
+	for c.err == nil {
+		if sep.kind == tokSlashSlash {
+			c.next()
+			c.nextSegment(2)
+			p.Steps = append(p.Steps, &Step{Axis: "descendant-or-self", Test: "*", Abbrev: true})
+			if t := c.peek(); t.kind == tokEOF || t.kind == tokPipe {
+				p.Steps = append(p.Steps, &Step{Axis: "child", Test: "*", Abbrev: true})
+				break
+			}
+		}
+		s := c.parseStep()
+		p.Steps = append(p.Steps, s)
+		if s.isValue() {
+			break
+		}
+		if sep = c.peek(); sep.kind == tokSlash {
+			c.next()
+			c.nextSegment(1)
+		} else if sep.kind != tokSlashSlash {
+			break
+		}
+	}
+	return p
+}
+
+// nextSegment moves the compiler to the n-th next segment of the path,
+// unless a filter is being parsed.
+func (c *compiler) nextSegment(n int) {
+	if c.depth == 0 {
+		c.seg += n
+	}
+}
+
+// atStep returns true if the next token starts a step.
+func (c *compiler) atStep() bool {
+	switch c.peek().kind {
+	case tokDot, tokDotDot, tokStar, tokName, tokAt:
+		return true
+	}
+	return false
+}
+
+// parseStep parses a step of a path and its filters, or a step
+// taking the @attr or the text() of the elements.
+func (c *compiler) parseStep() *Step {
+	s := &Step{}
+	t := c.next()
+	switch t.kind {
+	case tokAt:
+		if n := c.peek(); n.kind != tokName && n.kind != tokStar {
+			c.fail(c.unexpected(n), n)
+			return s
+		}
+		s.Axis, s.Test, s.Abbrev = "attribute", c.name(), true
+		return s
+	case tokDot:
+		s.Axis, s.Test, s.Abbrev = "self", "*", true
+	case tokDotDot:
+		s.Axis, s.Test, s.Abbrev = "parent", "*", true
+	case tokStar:
+		s.Axis, s.Test, s.Abbrev = "child", "*", true
+	case tokName:
+		if t.text == "text" && c.peek().kind == tokLParen && c.toks[1].kind == tokRParen {
+			c.next()
+			c.next()
+			s.Axis, s.Test, s.Abbrev = "child", "text()", true
+			return s
+		}
+		if c.peek().kind != tokColonColon {
+			c.pattern(t)
+			s.Axis, s.Test, s.Abbrev = "child", t.text, true
+			break
+		}
+		if axisSelector(t.text, nameTest{name: "*"}) == nil && t.text != "attribute" {
+			c.fail(ErrUnknownAxis, t)
+			return s
+		}
+		c.next()
+		if n := c.peek(); n.kind != tokName && n.kind != tokStar {
+			c.fail(ErrEmptyNodeTest, n)
+			return s
+		}
+		if s.Axis, s.Test = t.text, c.name(); s.Axis == "attribute" {
+			return s
+		}
+	default:
+		c.fail(c.unexpected(t), t)
+		return s
+	}
+	for c.err == nil && c.peek().kind == tokLBracket {
+		s.Filters = append(s.Filters, c.parseFilter())
+	}
+	return s
+}
+
+// name consumes and returns a tag or attribute name, checking it
+// if it is a glob pattern.
+func (c *compiler) name() string {
+	t := c.next()
+	c.pattern(t)
+	return t.text
+}
+
+// pattern checks that the name token t, if it is a glob pattern,
+// can be compiled.
+func (c *compiler) pattern(t token) {
+	if t.kind == tokName && isGlob(t.text) {
+		if _, err := compileGlob(t.text); err != nil {
+			c.fail(ErrPattern, t)
+		}
+	}
+}
+
+// parseFilter parses a filter expression contained within [brackets].
+func (c *compiler) parseFilter() Expr {
+	open := c.next()
+	if c.peek().kind == tokRBracket {
+		c.fail(ErrEmptyFilter, token{text: "[]", pos: open.pos})
+		return nil
+	}
+	c.depth++
+	var x Expr
+	if c.atSlice() {
+		x = c.parseSlice()
+	} else {
+		x = c.parseOr()
+	}
+	if t := c.peek(); t.kind == tokEOF {
+		c.fail(ErrBrackets, open)
+	} else {
+		c.expect(tokRBracket)
+	}
+	c.depth--
+	return x
+}
+
+// atSlice returns true if the next tokens start a [start:stop:step] slice.
+func (c *compiler) atSlice() bool {
+	t := c.peek()
+	if t.kind == tokNumber {
+		t = c.toks[1]
+	}
+	return t.kind == tokColon || t.kind == tokColonColon
+}
+
+// parseSlice parses a start:stop:step slice. Each of the values can
+// be omitted, as in [-3:] and [::2].
+func (c *compiler) parseSlice() Expr {
+	x := &SliceExpr{}
+	x.Start = c.parseBound(false)
+	if c.peek().kind == tokColonColon {
+		c.next()
+		x.Step = c.parseBound(true)
+		return x
+	}
+	c.expect(tokColon)
+	x.Stop = c.parseBound(false)
+	if c.err == nil && c.peek().kind == tokColon {
+		c.next()
+		x.Step = c.parseBound(true)
+	}
+	return x
+}
+
+// parseBound parses an optional index or step of a slice, returning nil
+// if it is omitted. It must be an integer, and a non-zero step.
+func (c *compiler) parseBound(step bool) *int {
+	t := c.peek()
+	if c.err != nil || t.kind != tokNumber {
+		return nil
+	}
+	c.next()
+	num, _ := parseNumber(t.text)
+	if num != math.Trunc(num) || step && num == 0 || math.Abs(num) > math.MaxInt32 {
+		c.fail(ErrSlice, t)
+		return nil
+	}
+	n := int(num)
+	return &n
+}
+
+// parseOr parses a sequence of expressions separated by "or".
+func (c *compiler) parseOr() Expr {
+	x := c.parseAnd()
+	for c.err == nil && c.peek().kind == tokName && c.peek().text == "or" {
+		c.next()
+		x = &BinaryExpr{"or", x, c.parseAnd()}
+	}
+	return x
+}
+
+// parseAnd parses a sequence of expressions separated by "and".
+func (c *compiler) parseAnd() Expr {
+	x := c.parseCondition()
+	for c.err == nil && c.peek().kind == tokName && c.peek().text == "and" {
+		c.next()
+		x = &BinaryExpr{"and", x, c.parseCondition()}
+	}
+	return x
+}
+
+// parseCondition parses a single condition: a primary expression, or
+// a comparison between two primary expressions, as @attr='text' or
+// string-length(tag)>=3, or a match with a regular expression, as
+// @id~='^[a-z]+-[0-9]+$'.
+func (c *compiler) parseCondition() Expr {
+	x := c.parsePrimary()
+	if c.err != nil || c.peek().kind != tokOp {
+		return x
+	}
+	if op := c.next().text; op != "~=" {
+		return &BinaryExpr{op, x, c.parsePrimary()}
+	}
+	return &BinaryExpr{"~=", x, c.parseRegexp()}
+}
+
+// parseRegexp parses a regular expression, that must be a 'text'
+// literal compiling as a regexp.
+func (c *compiler) parseRegexp() Expr {
+	t := c.peek()
+	if t.kind != tokString {
+		c.parsePrimary()
+		c.fail(ErrRegexp, t)
+		return nil
+	}
+	c.next()
+	if _, err := regexp.Compile(t.val); err != nil {
+		c.fail(ErrRegexp, t)
+	}
+	return &LiteralExpr{t.val}
+}
+
+// parsePrimary parses a (parenthesized expression), a function(call),
+// @attr, 'text', a number, a $variable, or a path as tag or .//tag[@attr].
+func (c *compiler) parsePrimary() Expr {
+	t := c.peek()
+	switch t.kind {
+	case tokLParen:
+		c.next()
+		x := c.parseOr()
+		c.expect(tokRParen)
+		return x
+	case tokAt:
+		c.next()
+		if n := c.peek(); n.kind != tokName && n.kind != tokStar {
+			c.fail(c.unexpected(n), n)
+			return nil
+		}
+		return &AttrExpr{c.name()}
+	case tokString:
+		c.next()
+		return &LiteralExpr{t.val}
+	case tokNumber:
+		c.next()
+		num, _ := parseNumber(t.text)
+		return &NumberExpr{num}
+	case tokVar:
+		c.next()
+		return &VarExpr{t.val}
+	case tokName:
+		if c.toks[1].kind == tokLParen {
+			return c.parseCall()
+		}
+		fallthrough
+	case tokDot, tokDotDot, tokStar, tokSlash, tokSlashSlash:
+		u := c.parseUnion()
+		if len(u.Paths) == 1 {
+			return u.Paths[0]
+		}
+		return u
+	}
+	c.fail(c.unexpected(t), t)
+	return nil
+}
+
+// parseCall parses a call to a function and its (arguments).
+func (c *compiler) parseCall() Expr {
+	t := c.next()
+	c.next()
+	call := &CallExpr{Name: t.text}
+	for c.err == nil && c.peek().kind != tokRParen {
+		if len(call.Args) > 0 {
+			c.expect(tokComma)
+		}
+		if t.text == "matches" && len(call.Args) == 1 {
+			call.Args = append(call.Args, c.parseRegexp())
+			continue
+		}
+		call.Args = append(call.Args, c.parseOr())
+	}
+	c.expect(tokRParen)
+	if c.err != nil {
+		return nil
+	}
+
+	fn, ok := lookupFunc(t.text, &c.opts)
+	switch {
+	case !ok:
+		c.fail(ErrUnknownFunction, t)
+		return nil
+	case functions[t.text] != nil && c.opts.Funcs[t.text] != nil:
+		c.fail(ErrBuiltinFunction, t)
+		return nil
+	case len(call.Args) < fn.minArgs || fn.maxArgs >= 0 && len(call.Args) > fn.maxArgs:
+		c.fail(ErrArguments, t)
+		return nil
+	}
+	return call
+}
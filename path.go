@@ -6,12 +6,20 @@
 package treepath
 
 import (
-	"strconv"
+	"context"
 	"strings"
 )
 
 // Element is the interface that must be satifsfied by a tree node in order to
 // enable the treepath FindElements search.
+//
+// Compatibility note: elements are compared by equality, so the same tree
+// node should always be represented by equal Element values, as a pointer
+// to the node, and the Parent of the root should be nil. This is required
+// by the absolute paths, the axes, and the orders other than Unordered.
+// Elements created anew by each call to Parent and Children still work
+// with the other paths, but they can match the same node more than once:
+// //li/.. matches the parent of each li.
 type Element interface {
 	// Parent returns the parent of the current element.
 	Parent() Element
@@ -20,6 +28,8 @@ type Element interface {
 	Children() []Element
 
 	// MatchTag returns true if the current element matches the Tag.
+	// A glob pattern, as h*, is passed to MatchTag only if the element
+	// does not implement the Tagger interface.
 	MatchTag(string) bool
 
 	// MatchTagText returns true if the current element matches both Tag and
@@ -37,25 +47,198 @@ type Element interface {
 
 // Path represents the compiled version of an XPath-like espression.
 type Path struct {
-	segments []segment
+	paths  [][]segment // the segments of each path of a | union
+	reads  []valueRead // the value read by each path of the union
+	order  Order
+	limits Limits
+	str    string          // the canonical form of the path
+	funcs  map[string]Func // the custom functions of the compile options
+	vars   []string        // the variables referenced by the path
+}
+
+// CompileOptions contains the options used to compile a path.
+type CompileOptions struct {
+	// Namespaces binds the namespace prefixes of the names of the path,
+	// as soap in soap:Body, to namespace URIs. The names with a bound
+	// prefix, and the ones with the "*" prefix, as *:Body, match the
+	// elements implementing the NamespacedElement interface by namespace
+	// URI and local name. Otherwise, the prefix is part of the name.
+	Namespaces map[string]string
+
+	// Funcs contains custom functions, by name, that can be called within
+	// the filter expressions of the path, besides the built-in functions
+	// and the ones registered with RegisterFunc. They take precedence over
+	// the registered functions, but the built-in functions cannot be
+	// redefined: a path calling one of them, as contains, does not compile
+	// if Funcs has a function with the same name, with an
+	// ErrBuiltinFunction error.
+	Funcs map[string]Func
+
+	// Order is the order of the elements returned by the path.
+	Order Order
+
+	// Limits bounds the resources used to evaluate the path. When a limit
+	// is exceeded, the evaluation stops: FindElementsContext returns the
+	// partial results with a *LimitError, the other methods return the
+	// partial results only.
+	Limits Limits
+
+	// SubtreePositions changes the positions of the filters of a step
+	// following "//". By default, as in XPath, they count the children of
+	// each parent: //div[1] selects every div that is the first div child
+	// of its parent. With SubtreePositions, they count the descendants in
+	// breadth-first order: //div[1] selects the first div of the subtree.
+	SubtreePositions bool
 }
 
 // CompilePath creates an optimized version of an XPath-like string that
 // can be used to query elements in an element tree.
+// A path starting with "/" is absolute: it is evaluated from the root of
+// the tree the element belongs to, as found following its parents.
+// A path starting with "//" is relative, and it is the same as ".//".
+// The string can contain the union of several paths separated by "|".
+// The comparisons of the filters, as [@priority>=2] and [@priority='2.0'],
+// compare the values as numbers if both of them are numbers, and as
+// strings otherwise, with any operator and whether a value is quoted or
+// not: [@priority='2.0'] matches priority="2". The other equalities with a
+// 'text' value are checked by MatchAttrText and MatchTagText.
+// If the path is invalid, the returned error is a *SyntaxError.
 func CompilePath(path string) (Path, error) {
-	var comp compiler
-	segments := comp.parsePath(path)
-	if comp.err != ErrPath("") {
-		return Path{nil}, comp.err
+	return CompilePathWith(path, CompileOptions{})
+}
+
+// CompilePathWith is like CompilePath, but it uses the given options.
+func CompilePathWith(path string, opts CompileOptions) (Path, error) {
+	comp := compiler{opts: opts, path: path}
+	u := comp.parse()
+	if comp.err != nil {
+		return Path{}, comp.err
 	}
-	return Path{segments}, nil
+	paths, reads := comp.compileUnion(u)
+	return Path{
+		paths:  paths,
+		reads:  reads,
+		order:  opts.Order,
+		limits: opts.Limits,
+		str:    u.String(),
+		funcs:  opts.Funcs,
+		vars:   comp.vars,
+	}, nil
+}
+
+// CompileAST creates a path from its syntax tree, as returned by Path.AST
+// or built by hand. The tree must be a *UnionExpr or a *PathExpr.
+// It is checked by compiling its canonical String form, so the Offset of
+// a *SyntaxError refers to that string. A name that is not made of name
+// characters, as "div]", is reported as an ErrSyntax error.
+func CompileAST(x Expr, opts CompileOptions) (Path, error) {
+	if x == nil {
+		return CompilePathWith("", opts)
+	}
+	str := x.String()
+	if tok, ok := invalidToken(x); ok {
+		return Path{}, &SyntaxError{ErrSyntax, str, strings.Index(str, tok), 0, tok}
+	}
+	return CompilePathWith(str, opts)
+}
+
+// String returns the canonical form of the path: "|", "or" and "and" are
+// surrounded by single spaces, the function arguments are separated by
+// ", ", the other tokens are not separated, the
+// 'text' values are in single quotes where possible, and the child::tag
+// steps are abbreviated. The canonical form compiles to the same path.
+func (path Path) String() string {
+	return path.str
 }
 
-// FindElements returns the descendant of root Element that matched the path.
-// NOTE: The root element is never matched.
+// AST returns the syntax tree of the path, as a union of one or more
+// paths. The tree is built anew on each call, so it can be modified
+// and compiled with CompileAST without affecting the path.
+// It returns nil for the zero Path.
+func (path Path) AST() *UnionExpr {
+	comp := compiler{opts: CompileOptions{Funcs: path.funcs}, path: path.str}
+	return comp.parse()
+}
+
+// FindElements returns the elements that matched the path, evaluated from
+// the root Element. The relative paths match the descendants of root, as
+// ./html and //p, and root itself, as . and //.; the absolute paths, the
+// .. steps and the axes can match any element of the tree, as / and
+// ./html/.. match root.
+// The elements are returned in the Order of the path. Unordered elements
+// matched by a union of paths are deduplicated, and returned in the order
+// of the paths of the union.
+// The $variables of the path are evaluated as empty sets of attributes:
+// FindElementsWith binds them.
 func (path Path) FindElements(root Element) []Element {
-	p := newPather()
-	return p.traverse(root, path)
+	p := newPather(path.limits)
+	results := p.traverse(root, path)
+	sortElements(results, path.order)
+	return results
+}
+
+// FindElementsContext is like FindElements, but it stops the evaluation
+// of the path when the context is done or when the Limits of the path are
+// exceeded. In that case, it returns the elements found so far together
+// with the context error or a *LimitError.
+func (path Path) FindElementsContext(ctx context.Context, root Element) ([]Element, error) {
+	p := newPather(path.limits)
+	p.ctx = ctx
+	results := p.traverse(root, path)
+	sortElements(results, path.order)
+	return results, p.err
+}
+
+// FindElement returns the first element that matched the path, evaluated
+// from the root Element as by FindElements, and whether it was found.
+// For Unordered paths, the traversal stops as soon as the first element is
+// found. For the other orders, all the matching elements must be found to
+// know which one comes first.
+// The $variables of the path are evaluated as empty sets of attributes,
+// so [@priority>$p] matches nothing.
+func (path Path) FindElement(root Element) (Element, bool) {
+	p := newPather(path.limits)
+	if path.order == Unordered {
+		p.max = 1
+	}
+	results := p.traverse(root, path)
+	if len(results) == 0 {
+		return nil, false
+	}
+	sortElements(results, path.order)
+	return results[0], true
+}
+
+// Exists returns true if any element matched the path, evaluated from the
+// root Element as by FindElements.
+// The traversal stops as soon as the first element is found.
+// The $variables of the path are evaluated as empty sets of attributes,
+// so [@priority>$p] matches nothing.
+func (path Path) Exists(root Element) bool {
+	p := newPather(path.limits)
+	p.max = 1
+	return len(p.traverse(root, path)) > 0
+}
+
+// unique returns true if the path cannot match the same element twice, so
+// that its results need not be deduplicated: it is not a union, and at
+// most one of its steps selects the descendants, while the others select
+// the root, the children or the element itself.
+func (path Path) unique() bool {
+	if len(path.paths) != 1 {
+		return false
+	}
+	descendants := 0
+	for _, seg := range path.paths[0] {
+		switch seg.sel.(type) {
+		case *selectRoot, *selectSelf, *selectSelfByTag, *selectChildren, *selectChildrenByTag:
+		case *selectDescendants, *selectDescendantsByTag, *selectDescendantsBFS:
+			descendants++
+		default:
+			return false
+		}
+	}
+	return descendants <= 1
 }
 
 // A segment is a portion of a path between "/" characters.
@@ -86,6 +269,14 @@ type pather struct {
 	inResults  map[Element]bool
 	candidates []Element
 	scratch    []Element // used by filters
+	max        int       // maximum number of results, or 0 for no limit
+	found      int       // number of results found
+	limits     Limits
+	visited    int              // number of elements selected
+	ctx        context.Context  // optional context of the traversal
+	err        error            // error that stopped the traversal
+	vars       map[string]value // values of the variables
+	unique     bool             // the results need not be deduplicated
 }
 
 // A node represents an element and the remaining path segments that
@@ -95,160 +286,48 @@ type node struct {
 	segments []segment
 }
 
-// A compiler generates a compiled path from a path string.
-type compiler struct {
-	err ErrPath
-}
-
-// ErrPath is returned by path functions when an invalid path is provided.
-type ErrPath string
-
-// Error returns the string describing a path error.
-func (err ErrPath) Error() string {
-	return "treepath: " + string(err)
-}
-
-// parsePath parses an XPath-like string describing a path
-// through an element tree and returns a slice of segment
-// descriptors.
-func (c *compiler) parsePath(path string) []segment {
-	// If path starts or ends with //, fix it
-	if strings.HasPrefix(path, "//") {
-		path = "." + path
-	}
-	if strings.HasSuffix(path, "//") {
-		path = path + "*"
-	}
-
-	// Paths cannot be absolute
-	if strings.HasPrefix(path, "/") {
-		c.err = ErrPath("paths cannot be absolute.")
-		return nil
-	}
-
-	// Split path into segment objects
-	var segments []segment
-	for _, s := range splitPath(path) {
-		segments = append(segments, c.parseSegment(s))
-		if c.err != ErrPath("") {
-			break
-		}
-	}
-	return segments
-}
-
-// splitPath splits a path in the segments between / characters.
-// It handles the / characters eventually contained in the text values of path.
-func splitPath(path string) []string {
-	pieces := make([]string, 0)
-	start := 0
-	inquote := false
-	for i := 0; i+1 <= len(path); i++ {
-		if path[i] == '\'' {
-			inquote = !inquote
-		} else if path[i] == '/' && !inquote {
-			pieces = append(pieces, path[start:i])
-			start = i + 1
-		}
-	}
-	return append(pieces, path[start:])
-}
-
-// parseSegment parses a path segment between / characters.
-func (c *compiler) parseSegment(path string) segment {
-	pieces := strings.Split(path, "[")
-	seg := segment{
-		sel:     c.parseSelector(pieces[0]),
-		filters: make([]filter, 0),
-	}
-	for i := 1; i < len(pieces); i++ {
-		fpath := pieces[i]
-		last := len(fpath) - 1
-		if last < 0 || fpath[last] != ']' {
-			c.err = ErrPath("path has invalid filter [brackets].")
-			break
-		}
-		seg.filters = append(seg.filters, c.parseFilter(fpath[:last]))
-	}
-	return seg
-}
-
-// parseSelector parses a selector at the start of a path segment.
-func (c *compiler) parseSelector(path string) selector {
-	switch path {
-	case ".":
-		return new(selectSelf)
-	case "..":
-		return new(selectParent)
-	case "*":
-		return new(selectChildren)
-	case "":
-		return new(selectDescendants)
-	default:
-		return newSelectChildrenByTag(path)
-	}
-}
-
-// parseFilter parses a path filter contained within [brackets].
-func (c *compiler) parseFilter(path string) filter {
-
-	if len(path) == 0 {
-		c.err = ErrPath("path contains an empty filter expression.")
-		return nil
-	}
-
-	// Filter contains [@attr='text'] or [tag='text']?
-	eqindex := strings.Index(path, "='")
-	if eqindex >= 0 {
-		rindex := nextIndex(path, "'", eqindex+2)
-		if rindex != len(path)-1 {
-			c.err = ErrPath("path has mismatched filter quotes.")
-			return nil
-		}
-		switch {
-		case path[0] == '@':
-			// [@attr='text']
-			return newFilterAttrText(path[1:eqindex], path[eqindex+2:rindex])
-		default:
-			// [tag='text']
-			return newFilterChildText(path[:eqindex], path[eqindex+2:rindex])
-		}
-	}
-	// Filter contains [@attr], [N] or [tag]
-	switch {
-	case path[0] == '@':
-		// [@attr]
-		return newFilterAttr(path[1:])
-	case isInteger(path):
-		// [N]
-		pos, _ := strconv.Atoi(path)
-		switch {
-		case pos > 0:
-			return newFilterPos(pos - 1)
-		default:
-			return newFilterPos(pos)
-		}
-	default:
-		// [tag]
-		return newFilterChild(path)
-	}
-
-}
-
+// apply selects the candidates from the element e and filters them.
+// If the pather is stopped meanwhile, no candidate is left.
 func (seg *segment) apply(e Element, p *pather) {
 	seg.sel.apply(e, p)
+	p.visited += len(p.candidates)
 	for _, f := range seg.filters {
+		if p.err != nil {
+			break
+		}
 		f.apply(p)
 	}
+	if p.err != nil {
+		p.candidates = p.candidates[0:0]
+	}
 }
 
-func newPather() *pather {
+func newPather(limits Limits) *pather {
 	return &pather{
 		queue:      newFifo(),
 		results:    make([]Element, 0),
 		inResults:  make(map[Element]bool),
 		candidates: make([]Element, 0),
 		scratch:    make([]Element, 0),
+		limits:     limits,
+	}
+}
+
+// fork returns a pather evaluating a nested path, or a branch of a union,
+// with the limits, and with the context, the variables and the number of
+// visited elements of p.
+func (p *pather) fork(limits Limits) *pather {
+	sub := newPather(limits)
+	sub.ctx, sub.vars, sub.visited = p.ctx, p.vars, p.visited
+	return sub
+}
+
+// join updates p with the number of visited elements of the pather sub,
+// returned by fork, and with the error that stopped it, if any.
+func (p *pather) join(sub *pather) {
+	p.visited = sub.visited
+	if p.err == nil {
+		p.err = sub.err
 	}
 }
 
@@ -256,24 +335,60 @@ func newPather() *pather {
 // and then returning all elements that match the path's selectors
 // and filters.
 func (p *pather) traverse(e Element, path Path) []Element {
-	for p.queue.Push(&node{e, path.segments}); p.queue.Len() > 0; {
-		p.eval(p.queue.Pop())
+	p.unique = path.unique()
+	p.check()
+	for _, segments := range path.paths {
+		for p.queue.Push(&node{e, segments}); p.queue.Len() > 0 && !p.done(); {
+			p.eval(p.queue.Pop())
+		}
+		if p.done() {
+			break
+		}
 	}
 	return p.results
 }
 
+// done returns true if the pather must stop the traversal: it has
+// collected the maximum number of results, or an error occurred.
+func (p *pather) done() bool {
+	return p.err != nil || p.max > 0 && p.found >= p.max
+}
+
 // eval evalutes the current path node by applying the remaining
 // path's selector rules against the node's element.
 func (p *pather) eval(n *node) {
 	p.candidates = p.candidates[0:0]
 	seg, remain := n.segments[0], n.segments[1:]
+
+	// The "//" step is expanded one element at a time: the element is
+	// evaluated against the remaining segments, and its children against
+	// the "//" step again. So the traversal stops as soon as the maximum
+	// number of results is found, without walking the whole subtree.
+	if _, ok := seg.sel.(*selectDescendants); ok && len(seg.filters) == 0 && len(remain) > 0 {
+		p.visited++
+		p.queue.Push(&node{n.e, remain})
+		for _, c := range n.e.Children() {
+			p.queue.Push(&node{c, n.segments})
+		}
+		p.check()
+		return
+	}
 	seg.apply(n.e, p)
 
 	if len(remain) == 0 {
 		for _, c := range p.candidates {
 			if in := p.inResults[c]; !in {
-				p.inResults[c] = true
+				if p.limits.MaxResults > 0 && p.found >= p.limits.MaxResults {
+					p.err = &LimitError{"results", p.limits.MaxResults}
+					break
+				}
+				if !p.unique {
+					p.inResults[c] = true
+				}
 				p.results = append(p.results, c)
+				if p.found++; p.done() {
+					break
+				}
 			}
 		}
 	} else {
@@ -281,4 +396,5 @@ func (p *pather) eval(n *node) {
 			p.queue.Push(&node{c, remain})
 		}
 	}
+	p.check()
 }
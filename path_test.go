@@ -1,34 +1,42 @@
 package treepath
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 // ----------------------------------------------------------------------------
 
 type Node struct {
-	Name     string  `xml:"name,attr"`
-	Class    string  `xml:"class,attr"`
-	Lang     string  `xml:"lang,attr"`
-	Parent   *Node   `xml:"-"`
-	Children []*Node `xml:"node"`
+	Name     string     `xml:"name,attr"`
+	Class    string     `xml:"class,attr"`
+	Lang     string     `xml:"lang,attr"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Parent   *Node      `xml:"-"`
+	Children []*Node    `xml:"node"`
 }
 
 const xmlNodes = `
 <doc>
 	<node name="html">
 		<node name="head">
-			<node name="title" />
+			<node name="title">Home</node>
 		</node>
 		<node name="body">
 			<node name="h1" class="title" />
 			<node name="div" class="content">
 				<node name="p" class="summary" />
 				<node name="ul">
-					<node name="li" />
-					<node name="li" />
+					<node name="li" priority="2" version="1.2.0" />
+					<node name="li" priority="10" version="1.10.0" />
 				</node>
 				<node name="p" />
 			</node>
@@ -38,7 +46,7 @@ const xmlNodes = `
 				<node name="div" class="sub-footer" lang="en">
 					<node name="p" />
 					<node name="p">
-						<node name="span" />
+						<node name="span">42</node>
 					</node>
 				</node>
 			</node>
@@ -75,6 +83,9 @@ var tests = []test{
 	{"//div[2]/ul", nil},
 	{"//p[2]/span", "span"},
 	{"//p[-1]/span", "span"},
+	{"//p[0]", nil},
+	{"//p[-0]", nil},
+	{"//p[0][@class]", nil},
 	{"./html/body/div[2]/div/p[2]/span", "span"},
 
 	//text queries
@@ -101,18 +112,214 @@ var tests = []test{
 	{"//div[@class='footer']/*/.[@class='sub-footer']/p/span", "span"},
 	{"//div[@class='footer']/*/.[@lang='de']/p/span", nil},
 
+	// boolean queries
+	{"//div[@class='content' or @class='footer']", []string{"div", "div"}},
+	{"//div[@class='footer' and @lang]", nil},
+	{"//div[@class='sub-footer' and @lang='en']/p/span", "span"},
+	{"//div[not(@lang)]/ul", "ul"},
+	{"//div[(@class='footer' or @class='content') and not(ul)]/div", "div"},
+	{"//div[ul or div]", []string{"div", "div"}},
+	{"//ul[li]", "ul"},
+	{"./html/*[not(@class)]", []string{"head", "body"}},
+	{"./html/body/*[not(@class)]", nil},
+
+	// comparison queries
+	{"//li[@priority>3]", "li"},
+	{"//li[@priority>=2]", []string{"li", "li"}},
+	{"//li[@priority<'3']", "li"},
+	{"//li[3<@priority]", "li"},
+	{"//li[@priority!=2]", "li"},
+	{"//li[@priority!='2.0']", "li"},
+	{"//li[@priority=2.0]", "li"},
+	{"//li[@priority='2.0']", "li"},
+	{"//li[@priority<='2.0' and @priority>='2.0']", "li"},
+	{"//li[@version='1.2.0']", "li"},
+	{"//li[@missing!=1]", nil},
+	{"//div[@class=@lang]", nil},
+	{"./html/head[title='Home']/title", "title"},
+	{"//head[*='Home']", "head"},
+	{"//head[*='Away']", nil},
+	{"//p[*='42']/span", "span"},
+	{"./html/head[title>'A']/title", "title"},
+	{"//p[span=42]", "p"},
+	{"//p[span>40 and span<50]/span", "span"},
+	{"//p[span<'5']", nil},
+
+	// any attribute queries
+	{"//div[@*='en']", "div"},
+	{"./html/body/*[@*]", []string{"h1", "div", "div"}},
+	{"./html/*[@*]", nil},
+	{"//ul/*[@*>5]", "li"},
+
+	// function queries
+	{"//li['a']", []string{"li", "li"}},
+	{"//*[contains(@class, 'foot')]", []string{"div", "div"}},
+	{"//*[starts-with(@class, 'sub')]", "div"},
+	{"//*[ends-with(@class, 'ary')]", "p"},
+	{"//*[string-length(@class)=5]", "h1"},
+	{"//*[string-length()=4]", "title"},
+	{"//*[normalize-space(text())='Home']", "title"},
+	{"//*[count(li)=2]", "ul"},
+	{"//*[count(*)>2]", []string{"body", "div", "div"}},
+	{"//div[count(@*)=2]", "div"},
+	{"//ul/li[position()=2][@priority=10]", "li"},
+	{"//ul/li[last()][@priority=10]", "li"},
+	{"//ul/*[position()<last()][@priority=2]", "li"},
+	{"//*[text()]", []string{"title", "span"}},
+	{"//*[local-name()='span']", "span"},
+	{"//*[name()='ul']/li[1][@priority=2]", "li"},
+	{"//span[number()=42]", "span"},
+	{"//span[number(text())>41]", "span"},
+	{"//li[boolean(@priority)]", []string{"li", "li"}},
+	{"//li[not(boolean(@missing)) and true() and not(false())]", []string{"li", "li"}},
+
+	// union queries
+	{"./html/head | ./html/body", []string{"head", "body"}},
+	{"//span | //title | //span/..", []string{"span", "title", "p"}},
+	{"//*[@class='a|b'] | //h1[@class='title' or @class='x|y']", "h1"},
+	{"//missing | //ul/li[1][@priority=2]", "li"},
+
+	// quoted and nested queries
+	{"//*[@class='a[b]/c' or @class=\"title\"]", "h1"},
+	{"//*[@class='x|y]'] | //span", "span"},
+	{"//*[starts-with('it\\'s', \"it's\") and text()='Home']", "title"},
+	{"//*[text() and contains(\"say \\\"hi\\\"\", '\"hi\"')]", []string{"title", "span"}},
+	{"//div[p[span]]", "div"},
+	{"//*[ul/li[@priority=10]]", "div"},
+	{"//div[.//span][@class='footer']", "div"},
+	{"//li[../..[@class='content']]", []string{"li", "li"}},
+	{"//p[count(../p | ../div)=3]", []string{"p", "p"}},
+
+	// value queries
+	{"//li/@priority", []string{"li", "li"}},
+	{"./html/body/*/@lang", nil},
+	{"//@lang", "div"},
+	{"//*/text()", []string{"title", "span"}},
+	{"//div/attribute::*", []string{"div", "div", "div"}},
+	{"//*[li/@priority=10]", "ul"},
+	{"//li[../../@class='content']", []string{"li", "li"}},
+	{"//p[span/text()>40]", "p"},
+
+	// pattern queries
+	{"//h*", []string{"html", "head", "h1"}},
+	{"//h[0-9]", "h1"},
+	{"//h[!0-9]*", []string{"html", "head"}},
+	{"//s?an", "span"},
+	{"//*[@ver*]", []string{"li", "li"}},
+	{"//*[@*ang]", "div"},
+	{"//li[@ver*='1.10.0']", "li"},
+	{"//li/@pri*", []string{"li", "li"}},
+	{"//p[s*n='42']", "p"},
+	{"//div[u?]", "div"},
+	{"//*[@class='*-footer']", nil},
+
+	// regexp queries
+	{"//li[@version~='^1\\.1[0-9]\\.']", "li"},
+	{"//li[not(@version ~= '^1\\.2')]", "li"},
+	{"//div[@class~='footer$']", []string{"div", "div"}},
+	{"//*[matches(name(), '^h[1-6]$')]", "h1"},
+	{"//*[text()~='^[0-9]+$']", "span"},
+	{"//p[span~='^4']", "p"},
+	{"//*[matches(@*, 'sub|summary')]", []string{"p", "div"}},
+
+	// slice queries
+	{"./html/body/*[1:]", []string{"div", "div"}},
+	{"./html/body/*[:-1]", []string{"h1", "div"}},
+	{"./html/body/*[::2]", []string{"h1", "div"}},
+	{"./html/body/*[1:2]", "div"},
+	{"./html/body/*[0:1]", "h1"},
+	{"./html/body/*[1:1]", nil},
+	{"./html/body/*[-5:-1:5]", "h1"},
+	{"./html/body/*[::-1]", []string{"div", "div", "h1"}},
+	{"./html/body/*[:0:-2]", "div"},
+	{"./html/body/*[::-1][1]/p[::-2]", "p"},
+	{"./html/body/*[-2:]/p", []string{"p", "p", "p", "p"}},
+	{"./html/body/div[2]/*[-1:][@lang]", "div"},
+	{"./html/body/*[position()<=2]", []string{"h1", "div"}},
+	{"./html/body/*[position()>1 and position()<last()]", "div"},
+
 	//parent queries
 	//{"./bookstore/book[@category='COOKING']/title/../../book[4]/title", "Learning XML"},
 	{"//li/..", []string{"ul", "ul"}},
 	{"//li[1]/../..[@class='content']", "div"},
 
 	//bad paths
-	{"/html", errorResult("treepath: paths cannot be absolute.")},
 	{"./html/body[]", errorResult("treepath: path contains an empty filter expression.")},
 	{"./html//p[@lang='en'", errorResult("treepath: path has invalid filter [brackets].")},
 	{"./html//p[@lang='en]", errorResult("treepath: path has mismatched filter quotes.")},
+	{"./html//p[@lang=\"en']", errorResult("treepath: path has mismatched filter quotes.")},
+	{"./ht ml", errorResult("treepath: path has invalid syntax.")},
+	{"", errorResult("treepath: path has invalid syntax.")},
+	{"//p[span[@a]", errorResult("treepath: path has invalid filter [brackets].")},
 	{"./html//p[@lang]a", errorResult("treepath: path has invalid filter [brackets].")},
 	{"./html[[]]", errorResult("treepath: path has invalid filter [brackets].")},
+	{"//div[@class or]", errorResult("treepath: path has invalid filter expression.")},
+	{"//div[not(@class]", errorResult("treepath: path has invalid filter expression.")},
+	{"//div[@class='a' @lang]", errorResult("treepath: path has invalid filter expression.")},
+	{"//li[@priority>]", errorResult("treepath: path has invalid filter expression.")},
+	{"//li[@priority!2]", errorResult("treepath: path has invalid filter expression.")},
+	{"//li[foo()]", errorResult("treepath: path has an unknown function.")},
+	{"//li[contains(@class)]", errorResult("treepath: path has a function with a wrong number of arguments.")},
+	{"//li[count(]", errorResult("treepath: path has invalid filter expression.")},
+	{"//li[count(li,]", errorResult("treepath: path has invalid filter expression.")},
+	{"./html |", errorResult("treepath: path has an empty union member.")},
+	{"| //li", errorResult("treepath: path has an empty union member.")},
+	{"./html/sibling::p", errorResult("treepath: path has an unknown axis.")},
+	{"./html/child::", errorResult("treepath: path has an empty axis node test.")},
+	{"//li/@priority/..", errorResult("treepath: path has invalid syntax.")},
+	{"//li/@priority[1]", errorResult("treepath: path has invalid filter [brackets].")},
+	{"//li/text()/..", errorResult("treepath: path has invalid syntax.")},
+	{"//h[9-0]", errorResult("treepath: path has an invalid name pattern.")},
+	{"//li[@version~='[0-9']", errorResult("treepath: path has an invalid regular expression.")},
+	{"//li[matches(@version, @priority)]", errorResult("treepath: path has an invalid regular expression.")},
+	{"//li[::0]", errorResult("treepath: path has an invalid [start:stop:step] slice.")},
+	{"//li[1.5:]", errorResult("treepath: path has an invalid [start:stop:step] slice.")},
+	{"//li[1:2:3:4]", errorResult("treepath: path has invalid filter expression.")},
+}
+
+// valueTests are evaluated from a NodeValue, since they need the same
+// Node to be the same Element: the unions and the parent steps deduplicate
+// the elements, the sibling axes find the element among the children of
+// its parent, and the absolute paths stop at the nil parent of the root.
+var valueTests = []test{
+	// deduplicated queries
+	{"//li|//ul/li", []string{"li", "li"}},
+	{"//div[@class]//.[@class]/p/span", "span"},
+	{"//li/..", "ul"},
+
+	// absolute queries
+	{"/html", "html"},
+	{"/html/body/h1", "h1"},
+	{"/", ""},
+	{"/.", ""},
+	{"/*", "html"},
+	{"/html//li", []string{"li", "li"}},
+	{"/html/head | /html/body/h1", []string{"head", "h1"}},
+	{"./html/body/../head/title/../../..", ""},
+
+	// axis queries
+	{"//span/ancestor::div", []string{"div", "div"}},
+	{"//span/ancestor::div[1][@class='sub-footer']", "div"},
+	{"//span/ancestor::div[2][@class='footer']", "div"},
+	{"//span/ancestor::*[@lang]", "div"},
+	{"//span/ancestor-or-self::span", "span"},
+	{"//span/parent::p", "p"},
+	{"//span/parent::div", nil},
+	{"//span/self::span", "span"},
+	{"//span/self::p", nil},
+	{"./html/child::body/child::*", []string{"h1", "div", "div"}},
+	{"./html/descendant::li", []string{"li", "li"}},
+	{"./html/descendant::div[3][@lang='en']", "div"},
+	{"./html/descendant-or-self::html", "html"},
+	{"//h1/following-sibling::*", []string{"div", "div"}},
+	{"//h1/following-sibling::div[2][@class='footer']", "div"},
+	{"//li/following-sibling::li", "li"},
+	{"//ul/preceding-sibling::p[@class='summary']", "p"},
+	{"//ul/following-sibling::*", "p"},
+	{"//title/following::h1", "h1"},
+	{"//title/following::li", []string{"li", "li"}},
+	{"//h1/preceding::*", []string{"title", "head"}},
+	{"//ul/preceding::*[1][@class='summary']", "p"},
 }
 
 func (n *Node) printTree(prefix string) {
@@ -148,7 +355,8 @@ func getRoot() (*Node, error) {
 
 // ----------------------------------------------------------------------------
 
-// NodeElement implements treepath.Element interface for Node
+// NodeElement implements treepath.Element interface, and the optional
+// Tagger, Attributer and Texter interfaces, for Node.
 type NodeElement struct{ *Node }
 
 // Parent returns the parent element.
@@ -175,29 +383,101 @@ func (e NodeElement) MatchTag(tag string) bool {
 
 // MatchTagText returns true if ...
 func (e NodeElement) MatchTagText(tag, text string) bool {
-	return false
+	return e.Name == tag && e.Text() == text
 }
 
 // MatchAttr returns true if ...
 func (e NodeElement) MatchAttr(attr string) bool {
-	switch attr {
-	case "class":
-		return e.Class != ""
-	case "lang":
-		return e.Lang != ""
-	}
-	return false
+	_, ok := e.Attr(attr)
+	return ok
 }
 
 // MatchAttrText returns true if ...
 func (e NodeElement) MatchAttrText(attr, text string) bool {
+	v, ok := e.Attr(attr)
+	return ok && v == text
+}
+
+// Tag returns the tag of the node.
+func (e NodeElement) Tag() string {
+	return e.Name
+}
+
+// Attrs returns the names of the attributes of the node.
+func (e NodeElement) Attrs() []string {
+	var names []string
+	if e.Class != "" {
+		names = append(names, "class")
+	}
+	if e.Lang != "" {
+		names = append(names, "lang")
+	}
+	for _, a := range e.Node.Attrs {
+		names = append(names, a.Name.Local)
+	}
+	return names
+}
+
+// Attr returns the value of the attribute, if any.
+func (e NodeElement) Attr(attr string) (string, bool) {
 	switch attr {
 	case "class":
-		return e.Class == text
+		return e.Class, e.Class != ""
 	case "lang":
-		return e.Lang == text
+		return e.Lang, e.Lang != ""
 	}
-	return false
+	for _, a := range e.Node.Attrs {
+		if a.Name.Local == attr {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Text returns the text of the node.
+func (e NodeElement) Text() string {
+	return strings.TrimSpace(e.Node.Text)
+}
+
+var (
+	_ Tagger     = NodeElement{}
+	_ Attributer = NodeElement{}
+	_ Texter     = NodeElement{}
+)
+
+// ----------------------------------------------------------------------------
+
+// NodeValue is a NodeElement whose Parent and Children are comparable
+// values, so that the same Node is always the same Element, as required
+// by the axes, the absolute paths and the orders. The *NodeElement
+// pointers, created anew by each call, are seen as different elements:
+// //li/.. matches the ul parent once for each li.
+type NodeValue struct{ NodeElement }
+
+// nodeValue returns the NodeValue of the node n.
+func nodeValue(n *Node) NodeValue {
+	return NodeValue{NodeElement{n}}
+}
+
+// Parent returns the parent element, or nil for the root element.
+func (e NodeValue) Parent() Element {
+	if e.Node.Parent == nil {
+		return nil
+	}
+	return nodeValue(e.Node.Parent)
+}
+
+// childrenCalls counts the calls to NodeValue.Children.
+var childrenCalls int
+
+// Children returns the child elements.
+func (e NodeValue) Children() []Element {
+	childrenCalls++
+	elements := make([]Element, len(e.Node.Children))
+	for j, c := range e.Node.Children {
+		elements[j] = nodeValue(c)
+	}
+	return elements
 }
 
 // ----------------------------------------------------------------------------
@@ -214,6 +494,20 @@ func findNodes(path Path, root *Node) []*Node {
 	return nodes
 }
 
+// findValues is like findNodes, but it evaluates the path from the
+// NodeValue of root.
+func findValues(path Path, root *Node) []*Node {
+	elements := path.FindElements(nodeValue(root))
+	if len(elements) == 0 {
+		return nil
+	}
+	nodes := make([]*Node, len(elements))
+	for j, e := range elements {
+		nodes[j] = e.(NodeValue).Node
+	}
+	return nodes
+}
+
 // ----------------------------------------------------------------------------
 
 func fail(t *testing.T, test test) {
@@ -228,46 +522,1103 @@ func TestPath(t *testing.T) {
 	}
 
 	for _, test := range tests {
+		checkPath(t, test, root, findNodes)
+	}
+	for _, test := range valueTests {
+		checkPath(t, test, root, findValues)
+	}
+}
+
+// checkPath checks the result of the test path, evaluated from root
+// with the find function.
+func checkPath(t *testing.T, test test, root *Node, find func(Path, *Node) []*Node) {
+	path, err := CompilePath(test.path)
+	if err != nil {
+		if r, ok := test.result.(errorResult); !ok || err.Error() != string(r) {
+			fail(t, test)
+		}
+		return
+	}
+
+	nodes := find(path, root)
+	//t.Logf("%s -> %d items", test.path, len(nodes))
+	//for j, e := range nodes {
+	//t.Logf("%d) %v\n", j, e)
+	//}
+
+	switch s := test.result.(type) {
+	case errorResult:
+		fail(t, test)
+	case nil:
+		if len(nodes) != 0 {
+			fail(t, test)
+		}
+	case string:
+		if nodes == nil || len(nodes) != 1 || nodes[0].Name != s {
+
+			for j, e := range nodes {
+				t.Logf("%d) %v\n", j, e)
+			}
+			fail(t, test)
+		}
+	case []string:
+		if nodes == nil || len(nodes) != len(s) {
+			fail(t, test)
+			return
+		}
+		for i := 0; i < len(nodes); i++ {
+			if nodes[i].Name != s[i] {
+				fail(t, test)
+				break
+			}
+		}
+	}
+}
+
+// semverGte returns true if the version args[0] is greater than or equal
+// to the version args[1].
+func semverGte(e Element, args []string) interface{} {
+	a, b := strings.Split(args[0], "."), strings.Split(args[1], ".")
+	for j := 0; j < len(a) && j < len(b); j++ {
+		x, _ := strconv.Atoi(a[j])
+		y, _ := strconv.Atoi(b[j])
+		if x != y {
+			return x > y
+		}
+	}
+	return len(a) >= len(b)
+}
+
+func TestFuncs(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+	RegisterFunc("semver-gte", semverGte)
+	opts := CompileOptions{Funcs: map[string]Func{
+		"double": func(e Element, args []string) interface{} {
+			n, _ := strconv.Atoi(args[0])
+			return 2 * n
+		},
+	}}
+
+	for _, test := range []struct {
+		path     string
+		opts     CompileOptions
+		priority []string
+	}{
+		{"//li[semver-gte(@version, '1.3.0')]", CompileOptions{}, []string{"10"}},
+		{"//li[semver-gte(@version, '1.2')]", CompileOptions{}, []string{"2", "10"}},
+		{"//li[double(@priority)=20]", opts, []string{"10"}},
+		{"//li[double(@priority)>2 and semver-gte(@version, '1.2.0')]", opts, []string{"2", "10"}},
+	} {
+		path, err := CompilePathWith(test.path, test.opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		nodes := findValues(path, root)
+		if len(nodes) != len(test.priority) {
+			t.Errorf("%s: expected %d nodes, found %d", test.path, len(test.priority), len(nodes))
+			continue
+		}
+		for j, n := range nodes {
+			if p, _ := (NodeElement{n}).Attr("priority"); p != test.priority[j] {
+				t.Errorf("%s: expected priority %s, found %s", test.path, test.priority[j], p)
+			}
+		}
+	}
+
+	// custom functions of the options are not available to other paths
+	_, err = CompilePath("//li[double(@priority)=20]")
+	var e ErrPath
+	if !errors.As(err, &e) || e != ErrPath("path has an unknown function.") {
+		t.Errorf("expected unknown function error, found %v", err)
+	}
+
+	// built-in functions cannot be redefined by the options
+	opts.Funcs["contains"] = func(e Element, args []string) interface{} {
+		return true
+	}
+	_, err = CompilePathWith("//li[double(@priority)=20 and contains(@version, '1.')]", opts)
+	var serr *SyntaxError
+	if !errors.As(err, &serr) || serr.Code != ErrBuiltinFunction || serr.Offset != 30 || serr.Token != "contains" {
+		t.Errorf("expected built-in function error, found %v", err)
+	}
+	if _, err := CompilePathWith("//li[double(@priority)=20]", opts); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestAbsolutePath(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+	span := root.Children[0].Children[1].Children[2].Children[2].Children[1].Children[0]
+
+	for _, test := range []struct {
+		path string
+		name string
+	}{
+		{"/html/head/title", "title"},
+		{"/html/body/div[1]/ul/li[2]", "li"},
+		{"..", "p"},
+		{"/html/head/title | ..", "title"},
+	} {
 		path, err := CompilePath(test.path)
 		if err != nil {
-			if r, ok := test.result.(errorResult); !ok || err.Error() != string(r) {
-				fail(t, test)
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		nodes := findValues(path, span)
+		if len(nodes) == 0 || nodes[0].Name != test.name {
+			t.Errorf("%s: expected %s, found %v", test.path, test.name, nodes)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, test := range []struct {
+		order Order
+		names string
+	}{
+		{Unordered, "h1 div div p div title"},
+		{DocumentOrder, "title h1 div p div div"},
+		{ReverseDocumentOrder, "div div p div h1 title"},
+		{BreadthFirstOrder, "title h1 div div p div"},
+	} {
+		path, err := CompilePathWith("//*[@class] | //title", CompileOptions{Order: test.order})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var names []string
+		for _, n := range findValues(path, root) {
+			names = append(names, n.Name)
+		}
+		if s := strings.Join(names, " "); s != test.names {
+			t.Errorf("order %d: expected %q, found %q", test.order, test.names, s)
+		}
+	}
+
+	// the children of each parent are fetched once to sort the elements
+	flat := flatTree(20000)
+	path, _ := CompilePath("//x")
+	childrenCalls = 0
+	path.FindElements(nodeValue(flat))
+	unordered := childrenCalls
+	path, _ = CompilePathWith("//x", CompileOptions{Order: ReverseDocumentOrder})
+	childrenCalls = 0
+	elements := path.FindElements(nodeValue(flat))
+	if len(elements) != 20000 || elements[0].(NodeValue).Node != flat.Children[19999] || childrenCalls > unordered+1 {
+		t.Errorf("expected 20000 sorted elements, found %d after %d calls vs %d", len(elements), childrenCalls, unordered)
+	}
+}
+
+func TestFindElement(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, test := range []struct {
+		path  string
+		name  string
+		found bool
+		early bool // traversal is expected to stop early
+	}{
+		{"//li", "li", true, true},
+		{"./html/body/*", "h1", true, false},
+		{"//missing | //title", "title", true, false},
+		{"//missing", "", false, false},
+	} {
+		path, err := CompilePath(test.path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.path, err)
+		}
+
+		childrenCalls = 0
+		all := path.FindElements(nodeValue(root))
+		allCalls := childrenCalls
+
+		childrenCalls = 0
+		e, found := path.FindElement(nodeValue(root))
+		switch {
+		case found != test.found:
+			t.Errorf("%s: expected found %v, found %v", test.path, test.found, found)
+		case found && e.(NodeValue).Name != test.name:
+			t.Errorf("%s: expected %s, found %s", test.path, test.name, e.(NodeValue).Name)
+		case found && e != all[0]:
+			t.Errorf("%s: FindElement differs from FindElements", test.path)
+		case test.early && childrenCalls >= allCalls:
+			t.Errorf("%s: expected early termination, found %d calls vs %d", test.path, childrenCalls, allCalls)
+		}
+		if exists := path.Exists(nodeValue(root)); exists != test.found {
+			t.Errorf("%s: expected exists %v, found %v", test.path, test.found, exists)
+		}
+	}
+
+	// ordered paths return the first element in their order
+	path, _ := CompilePathWith("//*[@class] | //title", CompileOptions{Order: DocumentOrder})
+	if e, _ := path.FindElement(nodeValue(root)); e.(NodeValue).Name != "title" {
+		t.Errorf("expected title, found %v", e)
+	}
+
+	// the first element is found without walking the whole tree
+	flat := flatTree(10000)
+	path, _ = CompilePath("//x")
+	childrenCalls = 0
+	if _, found := path.FindElement(nodeValue(flat)); !found || childrenCalls > 2 {
+		t.Errorf("FindElement: expected early termination, found %d calls", childrenCalls)
+	}
+	childrenCalls = 0
+	if !path.Exists(nodeValue(flat)) || childrenCalls > 2 {
+		t.Errorf("Exists: expected early termination, found %d calls", childrenCalls)
+	}
+}
+
+// flatTree returns a tree whose root has n children with the tag x.
+func flatTree(n int) *Node {
+	root := &Node{}
+	for j := 0; j < n; j++ {
+		root.Children = append(root.Children, &Node{Name: "x", Parent: root})
+	}
+	return root
+}
+
+// chainTree returns a tree of n nested elements with the tag x.
+func chainTree(n int) *Node {
+	root := &Node{}
+	for e, j := root, 0; j < n; j++ {
+		c := &Node{Name: "x", Parent: e}
+		e.Children = []*Node{c}
+		e = c
+	}
+	return root
+}
+
+func TestIter(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, order := range []Order{Unordered, DocumentOrder, ReverseDocumentOrder} {
+		for _, s := range []string{"//li", "//*[@class] | //title | //p", "//missing", "/html/head | /html/head"} {
+			path, err := CompilePathWith(s, CompileOptions{Order: order})
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", s, err)
 			}
+			all := path.FindElements(nodeValue(root))
+			it := path.Iter(nodeValue(root))
+			j := 0
+			for e, ok := it.Next(); ok; e, ok = it.Next() {
+				if j >= len(all) || e != all[j] {
+					t.Errorf("%s: order %d: unexpected element %d", s, order, j)
+					break
+				}
+				j++
+			}
+			if j != len(all) {
+				t.Errorf("%s: order %d: expected %d elements, found %d", s, order, len(all), j)
+			}
+		}
+	}
+
+	// the first element is found without traversing the whole tree
+	path, _ := CompilePath("//li")
+	childrenCalls = 0
+	path.FindElements(nodeValue(root))
+	allCalls := childrenCalls
+	childrenCalls = 0
+	if _, ok := path.Iter(nodeValue(root)).Next(); !ok || childrenCalls >= allCalls {
+		t.Errorf("expected lazy iteration, found %d calls vs %d", childrenCalls, allCalls)
+	}
+
+	// the iteration neither walks the whole tree to find the first element,
+	// nor keeps the elements already returned
+	path, _ = CompilePath("//x")
+	it := path.Iter(nodeValue(flatTree(10000)))
+	childrenCalls = 0
+	if _, ok := it.Next(); !ok || childrenCalls > 2 {
+		t.Errorf("expected lazy iteration, found %d calls", childrenCalls)
+	}
+	n := 1
+	for _, ok := it.Next(); ok; _, ok = it.Next() {
+		n++
+	}
+	if n != 10000 || len(it.p.inResults) != 0 {
+		t.Errorf("expected 10000 elements and no deduplication, found %d and %d", n, len(it.p.inResults))
+	}
+}
+
+func TestFindElementsContext(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, test := range []struct {
+		path   string
+		ctx    context.Context
+		limits Limits
+		count  int    // expected number of results
+		limit  string // expected exceeded limit
+	}{
+		{"//p", context.Background(), Limits{}, 6, ""},
+		{"//p", context.Background(), Limits{MaxResults: 6}, 6, ""},
+		{"//p", context.Background(), Limits{MaxResults: 2}, 2, "results"},
+		{"//p", context.Background(), Limits{MaxVisited: 5}, 0, "visited"},
+		{"./html/body/div", context.Background(), Limits{MaxVisited: 5}, 2, ""},
+		{"//p", context.Background(), Limits{MaxQueue: 3}, 0, "queue"},
+		{"//p", canceled, Limits{}, 0, ""},
+	} {
+		path, err := CompilePathWith(test.path, CompileOptions{Limits: test.limits})
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.path, err)
+		}
+		elements, err := path.FindElementsContext(test.ctx, nodeValue(root))
+		if len(elements) != test.count {
+			t.Errorf("%s %+v: expected %d elements, found %d", test.path, test.limits, test.count, len(elements))
+		}
+
+		var lerr *LimitError
+		switch {
+		case test.ctx == canceled:
+			if err != context.Canceled {
+				t.Errorf("%s: expected context error, found %v", test.path, err)
+			}
+		case test.limit == "":
+			if err != nil {
+				t.Errorf("%s %+v: unexpected error %v", test.path, test.limits, err)
+			}
+		case !errors.As(err, &lerr) || lerr.Limit != test.limit:
+			t.Errorf("%s %+v: expected %s limit error, found %v", test.path, test.limits, test.limit, err)
+		}
+	}
+	if s := (&LimitError{"results", 2}).Error(); s != "treepath: path exceeded the results limit of 2 elements." {
+		t.Errorf("unexpected limit error message %q", s)
+	}
+
+	// the paths nested in the filters count toward the limits, and
+	// stop with the context
+	chain := chainTree(4000)
+	path, err := CompilePathWith(".[count(.//*[count(.//*)>0])>0]", CompileOptions{Limits: Limits{MaxVisited: 10000}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	childrenCalls = 0
+	var lerr *LimitError
+	if _, err := path.FindElementsContext(context.Background(), nodeValue(chain)); !errors.As(err, &lerr) || lerr.Limit != "visited" || childrenCalls > 20000 {
+		t.Errorf("expected visited limit error, found %v after %d calls", err, childrenCalls)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	path, err = CompilePathWith(".[count(.//*[stop() and count(.//*)>0])>0]", CompileOptions{
+		Funcs: map[string]Func{"stop": func(e Element, args []string) interface{} {
+			stop()
+			return true
+		}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	childrenCalls = 0
+	if _, err := path.FindElementsContext(ctx, nodeValue(chain)); err != context.Canceled || childrenCalls > 8000 {
+		t.Errorf("expected context error, found %v after %d calls", err, childrenCalls)
+	}
+
+	// iterators stop at the limit too
+	path, _ = CompilePathWith("//p", CompileOptions{Limits: Limits{MaxResults: 2}})
+	it := path.Iter(nodeValue(root))
+	n := 0
+	for _, ok := it.Next(); ok; _, ok = it.Next() {
+		n++
+	}
+	if _, ok := it.Err().(*LimitError); n != 2 || !ok {
+		t.Errorf("expected 2 elements and a limit error, found %d and %v", n, it.Err())
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, test := range []struct {
+		path    string
+		code    ErrorCode
+		offset  int
+		segment int
+		token   string
+	}{
+		{"./html//p[@lang='en' or]", ErrExpression, 23, 3, "]"},
+		{"./html//p[@lang='en]", ErrQuotes, 16, 3, "'en]"},
+		{"./html/body[]", ErrEmptyFilter, 11, 2, "[]"},
+		{"./html//p[@lang]a", ErrBrackets, 16, 3, "a"},
+		{"//p[span[@a]", ErrBrackets, 3, 2, "["},
+		{"./ht ml", ErrSyntax, 5, 1, "ml"},
+		{"//div[@class or]", ErrExpression, 15, 2, "]"},
+		{"/html/sibling::p", ErrUnknownAxis, 6, 2, "sibling"},
+		{"./html/child::", ErrEmptyNodeTest, 14, 2, ""},
+		{"//li[contains(@a)] | ./x", ErrArguments, 5, 2, "contains"},
+		{"./x | //li[foo(@a)]", ErrUnknownFunction, 11, 2, "foo"},
+		{"./x | ", ErrEmptyUnion, 6, 0, ""},
+		{"//li[@a # 2]", ErrExpression, 8, 2, "#"},
+		{"//div[@d[z-a]*]", ErrPattern, 7, 2, "d[z-a]*"},
+		{"//li[@v~='(']", ErrRegexp, 9, 2, "'('"},
+		{"//li[matches(@v, $re)]", ErrRegexp, 17, 2, "$re"},
+		{"//li[2::0]", ErrSlice, 8, 2, "0"},
+	} {
+		_, err := CompilePath(test.path)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expected a syntax error, found %v", test.path, err)
 			continue
 		}
+		if serr.Code != test.code || serr.Offset != test.offset || serr.Segment != test.segment || serr.Token != test.token {
+			t.Errorf("%s: expected {%s %d %d %q}, found {%s %d %d %q}", test.path,
+				test.code, test.offset, test.segment, test.token,
+				serr.Code, serr.Offset, serr.Segment, serr.Token)
+		}
+		if serr.Path != test.path {
+			t.Errorf("%s: unexpected path %s", test.path, serr.Path)
+		}
+	}
 
-		nodes := findNodes(path, root)
-		//t.Logf("%s -> %d items", test.path, len(nodes))
-		//for j, e := range nodes {
-		//t.Logf("%d) %v\n", j, e)
-		//}
+	_, err := CompilePath("./html//p[@lang='en' or]")
+	excerpt := "./html//p[@lang='en' or]\n                       ^"
+	if s := err.(*SyntaxError).Excerpt(); s != excerpt {
+		t.Errorf("expected excerpt\n%s\nfound\n%s", excerpt, s)
+	}
+}
 
-		switch s := test.result.(type) {
-		case errorResult:
-			fail(t, test)
+func TestString(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, test := range []struct {
+		path string
+		str  string
+	}{
+		{"./html/body", "./html/body"},
+		{"  //li [ @priority = 2 ]", "//li[@priority=2]"},
+		{"./html//p", "./html//p"},
+		{"/", "/"},
+		{"/html|//li", "/html | //li"},
+		{"./html/child::body/descendant-or-self::*", "./html/body/descendant-or-self::*"},
+		{"//p[@class=\"summary\" or (@lang and not(@class))]", "//p[@class='summary' or @lang and not(@class)]"},
+		{"//p[(@a or @b) and @c]", "//p[(@a or @b) and @c]"},
+		{"//*[@a=\"it's\"][@b='say \"hi\"'][@c=\"'\\\"\"]", "//*[@a=\"it's\"][@b='say \"hi\"'][@c='\\'\"']"},
+		{"//*[like(@a, '\\d+\\\\')]", "//*[like(@a, '\\d+\\\\')]"},
+		{"//li[count(../li | ../p)>=2.50]", "//li[count(../li | ../p)>=2.5]"},
+		{"a//", "a//*"},
+		{"//li[ 2 : -1 ][::3][:]", "//li[2:-1][::3][:]"},
+		{"//li[0:0:-1][ : 0 ]", "//li[0:0:-1][:0]"},
+		{"//li[@v ~= '^\\d' and matches( text(), \"x\" )]", "//li[@v~='^\\d' and matches(text(), 'x')]"},
+	} {
+		path, err := CompilePathWith(test.path, CompileOptions{Funcs: map[string]Func{"like": semverGte}})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		if s := path.String(); s != test.str {
+			t.Errorf("%s: expected %s, found %s", test.path, test.str, s)
+		}
+	}
+
+	// The canonical form of a path compiles to the same path.
+	for _, test := range tests {
+		path, err := CompilePath(test.path)
+		if err != nil {
+			continue
+		}
+		path2, err := CompilePath(path.String())
+		if err != nil {
+			t.Errorf("%s: canonical form %s: unexpected error %v", test.path, path.String(), err)
+			continue
+		}
+		if s := path2.String(); s != path.String() {
+			t.Errorf("%s: canonical form %s changed to %s", test.path, path.String(), s)
+		}
+		nodes, nodes2 := findValues(path, root), findValues(path2, root)
+		if !reflect.DeepEqual(nodes, nodes2) {
+			t.Errorf("%s: canonical form %s found %v instead of %v", test.path, path.String(), nodes2, nodes)
+		}
+	}
+}
+
+func TestAST(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	path, _ := CompilePath("//ul/li[@priority>5]")
+	ast := path.AST()
+	if len(ast.Paths) != 1 || len(ast.Paths[0].Steps) != 3 {
+		t.Fatalf("unexpected AST %#v", ast)
+	}
+	step := ast.Paths[0].Steps[2]
+	cmp, ok := step.Filters[0].(*BinaryExpr)
+	if step.Axis != "child" || step.Test != "li" || !ok || cmp.Op != ">" {
+		t.Fatalf("unexpected step %#v", step)
+	}
+
+	// Rewriting the AST does not change the path.
+	cmp.Op = "<"
+	if s := path.String(); s != "//ul/li[@priority>5]" {
+		t.Errorf("path changed to %s", s)
+	}
+	path2, err := CompileAST(ast, CompileOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s := path2.String(); s != "//ul/li[@priority<5]" {
+		t.Errorf("expected //ul/li[@priority<5], found %s", s)
+	}
+	if nodes := findValues(path2, root); len(nodes) != 1 || nodes[0].Attrs[0].Value != "2" {
+		t.Errorf("expected the first li, found %v", nodes)
+	}
+
+	// Paths can be built from AST nodes.
+	x := &PathExpr{Absolute: true, Steps: []*Step{
+		{Axis: "descendant-or-self", Test: "*", Abbrev: true},
+		{Axis: "child", Test: "p", Filters: []Expr{
+			&BinaryExpr{"=", &CallExpr{"text", nil}, &LiteralExpr{"it's \\"}},
+		}},
+	}}
+	path3, err := CompileAST(x, CompileOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s := path3.String(); s != "/.//p[text()=\"it's \\\\\"]" {
+		t.Errorf("unexpected canonical form %s", s)
+	}
+
+	_, err = CompileAST(&PathExpr{Steps: []*Step{{Axis: "sibling", Test: "p"}}}, CompileOptions{})
+	var serr *SyntaxError
+	if !errors.As(err, &serr) || serr.Code != ErrUnknownAxis {
+		t.Errorf("expected an unknown axis error, found %v", err)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	body := Child("html").Child("body")
+	minus2, two := -2, 2
+	for _, test := range []struct {
+		b      *Builder
+		path   string
+		result interface{}
+	}{
+		{body.Child("*").HasAttr("class").Pos(-1), "html/body/*[@class][-1]", "div"},
+		{body.Child("div").Attr("class", "footer").Descendant().Child("p").Pos(-1), "html/body/div[@class='footer']//p[-1]", []string{"p", "p"}},
+		{Descendant().Child("title").Text("Home"), "//title[text()='Home']", "title"},
+		{Root().Child("html").Descendant().Child("li").Parent(), "/html//li/..", "ul"},
+		{Root().Descendant().Child("span").Axis("ancestor", "div").Pos(2), "/.//span/ancestor::div[2]", "div"},
+		{Descendant().Child("*").Attr("class", "x' or @class='title"), "//*[@class=\"x' or @class='title\"]", nil},
+		{Descendant().Child("*").Attr("class", `a\'b"]`), `//*[@class='a\\\'b"]']`, nil},
+		{Root().HasAttr("lang"), "/.[@lang]", nil},
+		{Descendant().Child("li").Filter(&BinaryExpr{">", &AttrExpr{"priority"}, &NumberExpr{5}}), "//li[@priority>5]", "li"},
+		{body.Child("*").Slice(&minus2, nil, nil).Child("p").Slice(nil, nil, &two), "html/body/*[-2:]/p[::2]", []string{"p", "p"}},
+	} {
+		if s := test.b.String(); s != test.path {
+			t.Errorf("expected %s, found %s", test.path, s)
+		}
+		path, err := test.b.Compile()
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		expected, _ := CompilePath(test.path)
+		if !reflect.DeepEqual(path, expected) {
+			t.Errorf("%s: the builder compiled a different path", test.path)
+		}
+		nodes := findValues(path, root)
+		switch r := test.result.(type) {
 		case nil:
 			if len(nodes) != 0 {
-				fail(t, test)
+				t.Errorf("%s: expected no results, found %v", test.path, nodes)
 			}
 		case string:
-			if nodes == nil || len(nodes) != 1 || nodes[0].Name != s {
-
-				for j, e := range nodes {
-					t.Logf("%d) %v\n", j, e)
-				}
-				fail(t, test)
+			if len(nodes) != 1 || nodes[0].Name != r {
+				t.Errorf("%s: expected %s, found %v", test.path, r, nodes)
 			}
 		case []string:
-			if nodes == nil || len(nodes) != len(s) {
-				fail(t, test)
-				continue
+			if len(nodes) != len(r) {
+				t.Errorf("%s: expected %v, found %v", test.path, r, nodes)
 			}
-			for i := 0; i < len(nodes); i++ {
-				if nodes[i].Name != s[i] {
-					fail(t, test)
-					break
-				}
+		}
+	}
+
+	// The builder is not changed by the methods.
+	if s := body.String(); s != "html/body" {
+		t.Errorf("expected html/body, found %s", s)
+	}
+
+	// Invalid names are reported.
+	for _, b := range []*Builder{
+		Descendant().Child("div | //p"),
+		Child("div").HasAttr("class]"),
+		Child("div").Axis("descendant::*/child", "p"),
+	} {
+		_, err := b.Compile()
+		var serr *SyntaxError
+		if !errors.As(err, &serr) || serr.Code != ErrSyntax {
+			t.Errorf("%s: expected a syntax error, found %v", b, err)
+		}
+	}
+}
+
+func TestFindElementsWith(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		vars   Vars
+		result []string
+	}{
+		{"//*[@class=$c]", Vars{"c": "footer"}, []string{"div"}},
+		{"//*[@class=$c]", Vars{"c": []string{"footer", "title"}}, []string{"h1", "div"}},
+		{"//li[@priority>$p]", Vars{"p": 5}, []string{"li"}},
+		{"//li[@priority>$p]", Vars{"p": 1.5}, []string{"li", "li"}},
+		{"//li[@priority=$p]", Vars{"p": "2.0"}, []string{"li"}},
+		{"//li[@version=$v]", Vars{"v": "1.2"}, nil},
+		{"//li[@priority=$p]", Vars{"p": 2.0}, []string{"li"}},
+		{"//*[name()=$n]", Vars{"n": "ul"}, []string{"ul"}},
+		{"//li[$all or @priority=10]", Vars{"all": true}, []string{"li", "li"}},
+		{"//li[$all or @priority=10]", Vars{"all": false}, []string{"li"}},
+		{"//*[ul[li[@priority=$p]]]", Vars{"p": 10, "unused": struct{}{}}, []string{"div"}},
+	} {
+		path, err := CompilePath(test.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		elements, err := path.FindElementsWith(nodeValue(root), test.vars)
+		if err != nil {
+			t.Errorf("%s %v: unexpected error %v", test.path, test.vars, err)
+			continue
+		}
+		var names []string
+		for _, e := range elements {
+			names = append(names, e.(NodeValue).Name)
+		}
+		if !reflect.DeepEqual(names, test.result) {
+			t.Errorf("%s %v: expected %v, found %v", test.path, test.vars, test.result, names)
+		}
+	}
+
+	path, _ := CompilePath("//*[@class=$c and @lang=$l]")
+	if s := path.String(); s != "//*[@class=$c and @lang=$l]" {
+		t.Errorf("unexpected canonical form %s", s)
+	}
+	for _, vars := range []Vars{{"c": "x"}, {"c": "x", "l": struct{}{}}} {
+		_, err := path.FindElementsWith(nodeValue(root), vars)
+		var verr *VarError
+		if !errors.As(err, &verr) || verr.Name != "l" {
+			t.Errorf("%v: expected a variable error, found %v", vars, err)
+		}
+	}
+	if nodes := findValues(path, root); len(nodes) != 0 {
+		t.Errorf("expected no results without variables, found %v", nodes)
+	}
+
+	// the limit errors are returned with the partial results
+	path, _ = CompilePathWith("//li[@priority>$x]", CompileOptions{Limits: Limits{MaxResults: 1}})
+	elements, err := path.FindElementsWith(nodeValue(root), Vars{"x": 1})
+	var lerr *LimitError
+	if len(elements) != 1 || !errors.As(err, &lerr) || lerr.Limit != "results" {
+		t.Errorf("expected 1 element and a results limit error, found %d and %v", len(elements), err)
+	}
+}
+
+func TestValues(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		values []string
+	}{
+		{"//li/@priority", []string{"2", "10"}},
+		{"//li[2]/@*", []string{"10", "1.10.0"}},
+		{"//div/@class", []string{"content", "footer", "sub-footer"}},
+		{"//*/text()", []string{"Home", "42"}},
+		{"//span", []string{"42"}},
+		{"//title/text() | //li/@version | //title/text()", []string{"Home", "1.2.0", "1.10.0"}},
+		{"//li/@priority | //li/@version", []string{"2", "10", "1.2.0", "1.10.0"}},
+		{"//li/@missing", []string{}},
+		{"/html/head/title/attribute::*", []string{}},
+	} {
+		path, err := CompilePath(test.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		if values := path.Values(nodeValue(root)); !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: expected %q, found %q", test.path, test.values, values)
+		}
+	}
+
+	path, _ := CompilePathWith("//li/@version", CompileOptions{Order: ReverseDocumentOrder})
+	if values := path.Values(nodeValue(root)); !reflect.DeepEqual(values, []string{"1.10.0", "1.2.0"}) {
+		t.Errorf("expected the versions in reverse order, found %q", values)
+	}
+	if s := path.String(); s != "//li/@version" {
+		t.Errorf("unexpected canonical form %s", s)
+	}
+}
+
+func TestEval(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, test := range []struct {
+		expr  string
+		kind  ValueKind
+		value string
+	}{
+		{"count(//li)", NumberKind, "2"},
+		{"sum(//li/@priority)", NumberKind, "12"},
+		{"sum(//li/@version)", NumberKind, "NaN"},
+		{"sum(//missing/@x)", NumberKind, "0"},
+		{"count(//p) > 5 and boolean(//span)", BoolKind, "true"},
+		{"boolean(//error)", BoolKind, "false"},
+		{"not(//error) or 1", BoolKind, "true"},
+		{"concat(name(//ul), ':', count(//ul/li), \"'s\")", StringKind, "ul:2's"},
+		{"string(//span)", StringKind, "42"},
+		{"//span = 42", BoolKind, "true"},
+		{"//li/@version", StringsKind, "1.2.0"},
+		{"//div", ElementsKind, ""},
+		{"html/head/title", ElementsKind, "Home"},
+		{"-1.5", NumberKind, "-1.5"},
+		{"matches(//span, '^\\d+$')", BoolKind, "true"},
+	} {
+		v, err := Eval(test.expr, nodeValue(root))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.expr, err)
+			continue
+		}
+		if v.Kind() != test.kind || v.String() != test.value {
+			t.Errorf("%s: expected %d %q, found %d %q", test.expr, test.kind, test.value, v.Kind(), v.String())
+		}
+	}
+
+	v, _ := Eval("//div[@class]", nodeValue(root))
+	if len(v.Elements()) != 3 || !v.Bool() || len(v.Strings()) != 3 {
+		t.Errorf("unexpected elements %v", v.Elements())
+	}
+
+	v, err = EvalWith("count(//li[@priority > $p])", nodeValue(root), CompileOptions{}, Vars{"p": 5})
+	if err != nil || v.Number() != 1 {
+		t.Errorf("expected 1, found %v %v", v, err)
+	}
+
+	for _, expr := range []string{"count(//li", "concat('a')", "", "//li]", "sum(//li/@priority) div 2"} {
+		_, err := Eval(expr, nodeValue(root))
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expected a syntax error, found %v", expr, err)
+		}
+	}
+}
+
+// globElement is an Element not implementing the Tagger and Attributer
+// interfaces: the glob patterns are passed to its Match methods.
+type globElement struct{ n NodeValue }
+
+func (e globElement) Parent() Element {
+	if p := e.n.Parent(); p != nil {
+		return globElement{p.(NodeValue)}
+	}
+	return nil
+}
+
+func (e globElement) Children() []Element {
+	children := e.n.Children()
+	for j, c := range children {
+		children[j] = globElement{c.(NodeValue)}
+	}
+	return children
+}
+
+func (e globElement) MatchTag(tag string) bool {
+	ok, _ := filepath.Match(tag, e.n.Name)
+	return ok
+}
+
+func (e globElement) MatchTagText(tag, text string) bool {
+	return e.MatchTag(tag) && e.n.Text() == text
+}
+
+func (e globElement) MatchAttr(attr string) bool {
+	for _, name := range e.n.Attrs() {
+		if ok, _ := filepath.Match(attr, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e globElement) MatchAttrText(attr, text string) bool {
+	for _, name := range e.n.Attrs() {
+		if ok, _ := filepath.Match(attr, name); ok && e.n.MatchAttrText(name, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPatterns(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	for _, test := range []struct {
+		path  string
+		count int
+	}{
+		{"//h*", 3},
+		{"//h[^0-9]*", 2},
+		{"//*[@ver*]", 2},
+		{"//*[@*ang]", 1},
+		{"//p[s?an='42']", 1},
+		{"//li[@p*='2']", 1},
+	} {
+		path, err := CompilePath(test.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		if s := path.String(); s != test.path {
+			t.Errorf("%s: unexpected string %s", test.path, s)
+		}
+		if n := len(path.FindElements(nodeValue(root))); n != test.count {
+			t.Errorf("%s: expected %d elements, found %d", test.path, test.count, n)
+		}
+		if n := len(path.FindElements(globElement{nodeValue(root)})); n != test.count {
+			t.Errorf("%s: expected %d glob elements, found %d", test.path, test.count, n)
+		}
+	}
+
+	if _, err := Child("h[1-6]").HasAttr("data-*").Compile(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSubtreePositions(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+
+	names := func(nodes []*Node) []string {
+		var names []string
+		for _, n := range nodes {
+			name := n.Name + ":" + n.Class
+			if len(n.Children) > 0 {
+				name += "/" + n.Children[0].Name
 			}
+			names = append(names, name)
+		}
+		return names
+	}
+
+	for _, test := range []struct {
+		path              string
+		parents, subtrees []string
+	}{
+		{"//p[1]", []string{"p:summary", "p:", "p:"}, []string{"p:summary"}},
+		{"//p[-1]", []string{"p:", "p:", "p:/span"}, []string{"p:/span"}},
+		{"//p[0]", nil, nil},
+		{"//div[1]", []string{"div:content/p", "div:sub-footer/p"}, []string{"div:content/p"}},
+		{"./html//*[2]", []string{"body:/h1", "div:content/p", "ul:/li", "p:", "li:", "p:/span"}, []string{"body:/h1"}},
+		{"//p[span or @class][1:]", nil, []string{"p:/span"}},
+		{"//div[@class]/p[1]", []string{"p:summary", "p:", "p:"}, []string{"p:summary", "p:", "p:"}},
+		{"//div//p[1]", []string{"p:summary", "p:", "p:"}, []string{"p:summary", "p:", "p:"}},
+		{"//p", []string{"p:summary", "p:", "p:", "p:", "p:", "p:/span"}, []string{"p:summary", "p:", "p:", "p:", "p:", "p:/span"}},
+	} {
+		path, err := CompilePath(test.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		if found := names(findValues(path, root)); !reflect.DeepEqual(found, test.parents) {
+			t.Errorf("%s: expected %v, found %v", test.path, test.parents, found)
+		}
+
+		path, err = CompilePathWith(test.path, CompileOptions{SubtreePositions: true})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		if found := names(findValues(path, root)); !reflect.DeepEqual(found, test.subtrees) {
+			t.Errorf("%s: expected %v with SubtreePositions, found %v", test.path, test.subtrees, found)
+		}
+	}
+}
+
+// nsNode is a node of a tree with namespaces, as decoded by encoding/xml.
+type nsNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []*nsNode  `xml:",any"`
+	parent   *nsNode
+}
+
+// nsElement implements the treepath.Element and NamespacedElement
+// interfaces for nsNode. The Match methods match the local names
+// without a namespace.
+type nsElement struct{ *nsNode }
+
+func (e nsElement) Parent() Element {
+	if e.parent == nil {
+		return nil
+	}
+	return nsElement{e.parent}
+}
+
+func (e nsElement) Children() []Element {
+	elements := make([]Element, len(e.nsNode.Children))
+	for j, c := range e.nsNode.Children {
+		elements[j] = nsElement{c}
+	}
+	return elements
+}
+
+func (e nsElement) MatchTag(tag string) bool {
+	return e.XMLName.Space == "" && e.XMLName.Local == tag
+}
+
+func (e nsElement) MatchTagText(tag, text string) bool {
+	return false
+}
+
+func (e nsElement) MatchAttr(attr string) bool {
+	_, ok := e.AttrNS(Name{"", attr})
+	return ok
+}
+
+func (e nsElement) MatchAttrText(attr, text string) bool {
+	v, ok := e.AttrNS(Name{"", attr})
+	return ok && v == text
+}
+
+func (e nsElement) TagNS() Name {
+	return Name(e.XMLName)
+}
+
+func (e nsElement) AttrsNS() []Name {
+	names := make([]Name, len(e.Attrs))
+	for j, a := range e.Attrs {
+		names[j] = Name(a.Name)
+	}
+	return names
+}
+
+func (e nsElement) AttrNS(name Name) (string, bool) {
+	for _, a := range e.Attrs {
+		if Name(a.Name) == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestNamespaces(t *testing.T) {
+	var roots []Element
+	for _, doc := range []string{
+		`<s:Envelope xmlns:s="urn:soap" xmlns:a="urn:app">
+			<s:Header/>
+			<s:Body><a:item a:id="1" id="x"/><item a:id="2"/></s:Body>
+		</s:Envelope>`,
+		`<soap:Envelope xmlns:soap="urn:soap">
+			<soap:Body xmlns="urn:app"><item xmlns:app="urn:app" app:id="3"/></soap:Body>
+		</soap:Envelope>`,
+	} {
+		root := new(nsNode)
+		if err := xml.Unmarshal([]byte(doc), root); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		var setParent func(*nsNode)
+		setParent = func(n *nsNode) {
+			for _, c := range n.Children {
+				c.parent = n
+				setParent(c)
+			}
+		}
+		setParent(root)
+		roots = append(roots, nsElement{&nsNode{Children: []*nsNode{root}}})
+	}
+
+	opts := CompileOptions{Namespaces: map[string]string{"env": "urn:soap", "app": "urn:app"}}
+	for _, test := range []struct {
+		path   string
+		counts [2]int
+	}{
+		{"./env:Envelope/env:Body", [2]int{1, 1}},
+		{"//env:Body/app:item", [2]int{1, 1}},
+		{"//env:Body/item", [2]int{1, 0}},
+		{"//*:Body/*:item", [2]int{2, 1}},
+		{"//env:*", [2]int{3, 2}},
+		{"//app:*[@app:id]", [2]int{1, 1}},
+		{"//*[@app:id='2']", [2]int{1, 0}},
+		{"//*[@*:id]", [2]int{2, 1}},
+		{"//*[@id]", [2]int{1, 0}},
+		{"//*[local-name()='item']", [2]int{2, 1}},
+		{"//soap:Body", [2]int{0, 0}},
+	} {
+		path, err := CompilePathWith(test.path, opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		for j, root := range roots {
+			if n := len(path.FindElements(root)); n != test.counts[j] {
+				t.Errorf("%s: expected %d elements in document %d, found %d", test.path, test.counts[j], j+1, n)
+			}
+		}
+	}
+
+	path, _ := CompilePathWith("//*:item/@app:id", opts)
+	if values := path.Values(roots[0]); !reflect.DeepEqual(values, []string{"1", "2"}) {
+		t.Errorf("unexpected values %v", values)
+	}
+
+	// The elements not implementing NamespacedElement match the prefixed names as written.
+	root := new(Node)
+	if err := xml.Unmarshal([]byte(`<doc><node name="env:Body"/><node name="soap:Body"/></doc>`), root); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	for str, count := range map[string]int{"./env:Body": 1, "./*:Body": 2, "./env:*": 1, "./Body": 0} {
+		path, err := CompilePathWith(str, opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", str, err)
+			continue
+		}
+		if n := len(findValues(path, root)); n != count {
+			t.Errorf("%s: expected %d nodes, found %d", str, count, n)
 		}
 	}
 }
@@ -13,6 +13,19 @@ func (s *selectSelf) apply(e Element, p *pather) {
 
 // ----------------------------------------------------------------------------
 
+// selectRoot selects the root of the tree the element belongs to
+// into the candidate list.
+type selectRoot struct{}
+
+func (s *selectRoot) apply(e Element, p *pather) {
+	for parent := e.Parent(); parent != nil; parent = e.Parent() {
+		e = parent
+	}
+	p.candidates = append(p.candidates, e)
+}
+
+// ----------------------------------------------------------------------------
+
 // selectParent selects the element's parent into the candidate list.
 type selectParent struct{}
 
@@ -43,7 +56,7 @@ type selectDescendants struct{}
 func (s *selectDescendants) apply(e Element, p *pather) {
 	q := queue.NewFifo(0)
 
-	for q.Push(e); q.Len() > 0; {
+	for q.Push(e); q.Len() > 0 && !p.stopped(); {
 		e := q.Pop().(Element)
 		p.candidates = append(p.candidates, e)
 		for _, c := range e.Children() {
@@ -54,20 +67,248 @@ func (s *selectDescendants) apply(e Element, p *pather) {
 
 // ----------------------------------------------------------------------------
 
+// selectDescendantsBFS selects into the candidate list all descendant
+// elements of the element having the specified tag, in breadth-first order.
+type selectDescendantsBFS struct {
+	tag nameTest
+}
+
+func newSelectDescendantsBFS(tag nameTest) *selectDescendantsBFS {
+	return &selectDescendantsBFS{tag}
+}
+
+func (s *selectDescendantsBFS) apply(e Element, p *pather) {
+	q := queue.NewFifo(0)
+
+	for q.Push(e); q.Len() > 0 && !p.stopped(); {
+		for _, c := range q.Pop().(Element).Children() {
+			if matchTag(c, s.tag) {
+				p.candidates = append(p.candidates, c)
+			}
+			q.Push(c)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
 // selectChildrenByTag selects into the candidate list all child
 // elements of the element having the specified tag.
 type selectChildrenByTag struct {
-	tag string
+	tag nameTest
 }
 
-func newSelectChildrenByTag(tag string) *selectChildrenByTag {
+func newSelectChildrenByTag(tag nameTest) *selectChildrenByTag {
 	return &selectChildrenByTag{tag}
 }
 
 func (s *selectChildrenByTag) apply(e Element, p *pather) {
 	for _, c := range e.Children() {
-		if c.MatchTag(s.tag) {
+		if matchTag(c, s.tag) {
+			p.candidates = append(p.candidates, c)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectDescendantsByTag selects into the candidate list all descendant
+// elements of the element having the specified tag, in document order.
+// If self is true, the element itself is considered too.
+type selectDescendantsByTag struct {
+	tag  nameTest
+	self bool
+}
+
+func newSelectDescendantsByTag(tag nameTest, self bool) *selectDescendantsByTag {
+	return &selectDescendantsByTag{tag, self}
+}
+
+func (s *selectDescendantsByTag) apply(e Element, p *pather) {
+	if s.self {
+		selectSubtree(e, s.tag, p)
+		return
+	}
+	for _, c := range e.Children() {
+		selectSubtree(c, s.tag, p)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectSelfByTag selects the current element into the candidate list
+// if it has the specified tag.
+type selectSelfByTag struct {
+	tag nameTest
+}
+
+func newSelectSelfByTag(tag nameTest) *selectSelfByTag {
+	return &selectSelfByTag{tag}
+}
+
+func (s *selectSelfByTag) apply(e Element, p *pather) {
+	if matchTag(e, s.tag) {
+		p.candidates = append(p.candidates, e)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectParentByTag selects the element's parent into the candidate list
+// if it has the specified tag.
+type selectParentByTag struct {
+	tag nameTest
+}
+
+func newSelectParentByTag(tag nameTest) *selectParentByTag {
+	return &selectParentByTag{tag}
+}
+
+func (s *selectParentByTag) apply(e Element, p *pather) {
+	if parent := e.Parent(); parent != nil && matchTag(parent, s.tag) {
+		p.candidates = append(p.candidates, parent)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectAncestors selects into the candidate list the ancestors of the
+// element having the specified tag, nearest first.
+// If self is true, the element itself is considered too.
+type selectAncestors struct {
+	tag  nameTest
+	self bool
+}
+
+func newSelectAncestors(tag nameTest, self bool) *selectAncestors {
+	return &selectAncestors{tag, self}
+}
+
+func (s *selectAncestors) apply(e Element, p *pather) {
+	if !s.self {
+		e = e.Parent()
+	}
+	for ; e != nil; e = e.Parent() {
+		if matchTag(e, s.tag) {
+			p.candidates = append(p.candidates, e)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectFollowingSiblings selects into the candidate list the siblings
+// after the element having the specified tag, in document order.
+type selectFollowingSiblings struct {
+	tag nameTest
+}
+
+func newSelectFollowingSiblings(tag nameTest) *selectFollowingSiblings {
+	return &selectFollowingSiblings{tag}
+}
+
+func (s *selectFollowingSiblings) apply(e Element, p *pather) {
+	children, i := siblings(e)
+	for _, c := range children[i+1:] {
+		if matchTag(c, s.tag) {
 			p.candidates = append(p.candidates, c)
 		}
 	}
 }
+
+// ----------------------------------------------------------------------------
+
+// selectPrecedingSiblings selects into the candidate list the siblings
+// before the element having the specified tag, nearest first.
+type selectPrecedingSiblings struct {
+	tag nameTest
+}
+
+func newSelectPrecedingSiblings(tag nameTest) *selectPrecedingSiblings {
+	return &selectPrecedingSiblings{tag}
+}
+
+func (s *selectPrecedingSiblings) apply(e Element, p *pather) {
+	children, i := siblings(e)
+	for j := i - 1; j >= 0; j-- {
+		if matchTag(children[j], s.tag) {
+			p.candidates = append(p.candidates, children[j])
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectFollowing selects into the candidate list all the elements
+// after the element having the specified tag, in document order.
+// Descendants of the element are not selected.
+type selectFollowing struct {
+	tag nameTest
+}
+
+func newSelectFollowing(tag nameTest) *selectFollowing {
+	return &selectFollowing{tag}
+}
+
+func (s *selectFollowing) apply(e Element, p *pather) {
+	for ; e != nil; e = e.Parent() {
+		children, i := siblings(e)
+		for _, c := range children[i+1:] {
+			selectSubtree(c, s.tag, p)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectPreceding selects into the candidate list all the elements
+// before the element having the specified tag, nearest first.
+// Ancestors of the element are not selected.
+type selectPreceding struct {
+	tag nameTest
+}
+
+func newSelectPreceding(tag nameTest) *selectPreceding {
+	return &selectPreceding{tag}
+}
+
+func (s *selectPreceding) apply(e Element, p *pather) {
+	for ; e != nil; e = e.Parent() {
+		children, i := siblings(e)
+		for j := i - 1; j >= 0; j-- {
+			selectSubtreeReverse(children[j], s.tag, p)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// selectSubtree selects into the candidate list the elements of the
+// subtree rooted at e having the specified tag, in document order.
+func selectSubtree(e Element, tag nameTest, p *pather) {
+	if p.stopped() {
+		return
+	}
+	if matchTag(e, tag) {
+		p.candidates = append(p.candidates, e)
+	}
+	for _, c := range e.Children() {
+		selectSubtree(c, tag, p)
+	}
+}
+
+// selectSubtreeReverse selects into the candidate list the elements of
+// the subtree rooted at e having the specified tag, in reverse document
+// order.
+func selectSubtreeReverse(e Element, tag nameTest, p *pather) {
+	if p.stopped() {
+		return
+	}
+	children := e.Children()
+	for j := len(children) - 1; j >= 0; j-- {
+		selectSubtreeReverse(children[j], tag, p)
+	}
+	if matchTag(e, tag) {
+		p.candidates = append(p.candidates, e)
+	}
+}
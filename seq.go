@@ -0,0 +1,19 @@
+//go:build go1.23
+
+package treepath
+
+import "iter"
+
+// All returns an iterator over the elements that matched the path,
+// evaluated from the root Element as by FindElements.
+// It is the range-over-func version of Iter.
+func (path Path) All(root Element) iter.Seq[Element] {
+	return func(yield func(Element) bool) {
+		it := path.Iter(root)
+		for e, ok := it.Next(); ok; e, ok = it.Next() {
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build go1.23
+
+package treepath
+
+import "testing"
+
+func TestAll(t *testing.T) {
+	root, err := getRoot()
+	if err != nil {
+		t.Fatalf("getRoot error: %v", err)
+	}
+	path, _ := CompilePath("//p")
+
+	var names []string
+	for e := range path.All(nodeValue(root)) {
+		names = append(names, e.(NodeValue).Name)
+		if len(names) == 2 {
+			break
+		}
+	}
+	if len(names) != 2 {
+		t.Errorf("expected 2 elements, found %d", len(names))
+	}
+}
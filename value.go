@@ -0,0 +1,187 @@
+package treepath
+
+import (
+	"math"
+	"strconv"
+)
+
+// A ValueKind identifies the type of a Value.
+type ValueKind int
+
+const (
+	BoolKind     ValueKind = iota
+	NumberKind             // a float64 number
+	StringKind             // a single string
+	StringsKind            // the strings of a set of attributes or texts
+	ElementsKind           // a set of elements
+)
+
+// A value is the result of the evaluation of an expr.
+type value struct {
+	kind  ValueKind
+	b     bool
+	num   float64
+	str   string
+	strs  []string
+	nodes []Element
+}
+
+func boolValue(b bool) value {
+	return value{kind: BoolKind, b: b}
+}
+
+func numberValue(num float64) value {
+	return value{kind: NumberKind, num: num}
+}
+
+func stringValue(str string) value {
+	return value{kind: StringKind, str: str}
+}
+
+func stringsValue(strs []string) value {
+	return value{kind: StringsKind, strs: strs}
+}
+
+func nodesValue(nodes []Element) value {
+	return value{kind: ElementsKind, nodes: nodes}
+}
+
+// toBool converts the value to a boolean. A set is true if it is not
+// empty, a string if it is not empty and a number if it is not zero.
+func (v value) toBool() bool {
+	switch v.kind {
+	case BoolKind:
+		return v.b
+	case NumberKind:
+		return v.num != 0 && !math.IsNaN(v.num)
+	case StringKind:
+		return v.str != ""
+	case StringsKind:
+		return len(v.strs) > 0
+	default:
+		return len(v.nodes) > 0
+	}
+}
+
+// toString converts the value to a string. A set is converted to its
+// first string, or to the empty string if it is empty.
+func (v value) toString() string {
+	switch v.kind {
+	case BoolKind:
+		if v.b {
+			return "true"
+		}
+		return "false"
+	case NumberKind:
+		return formatNumber(v.num)
+	case StringKind:
+		return v.str
+	default:
+		if strs := v.toStrings(); len(strs) > 0 {
+			return strs[0]
+		}
+		return ""
+	}
+}
+
+// toNumber converts the value to a number. It returns NaN if the string
+// of the value is not a number.
+func (v value) toNumber() float64 {
+	switch v.kind {
+	case BoolKind:
+		if v.b {
+			return 1
+		}
+		return 0
+	case NumberKind:
+		return v.num
+	default:
+		if num, ok := parseNumber(v.toString()); ok {
+			return num
+		}
+		return math.NaN()
+	}
+}
+
+// toStrings returns the strings of the value. The strings of a set of
+// elements are the texts of the elements implementing the Texter interface.
+func (v value) toStrings() []string {
+	switch v.kind {
+	case StringsKind:
+		return v.strs
+	case ElementsKind:
+		var strs []string
+		for _, e := range v.nodes {
+			if t, ok := textValue(e); ok {
+				strs = append(strs, t)
+			}
+		}
+		return strs
+	default:
+		return []string{v.toString()}
+	}
+}
+
+// count returns the number of items of a set value, or 1 if the value
+// is not a set.
+func (v value) count() int {
+	switch v.kind {
+	case StringsKind:
+		return len(v.strs)
+	case ElementsKind:
+		return len(v.nodes)
+	default:
+		return 1
+	}
+}
+
+// formatNumber returns the shortest string representing the number f.
+func formatNumber(f float64) string {
+	if math.IsNaN(f) {
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ----------------------------------------------------------------------------
+
+// A Value is the result of the evaluation of an expression by Eval.
+// It can be converted to any type, following the rules of the filter
+// expressions: a set is true if it is not empty, and its string is
+// its first string.
+type Value struct {
+	v value
+}
+
+// Kind returns the type of the value.
+func (v Value) Kind() ValueKind {
+	return v.v.kind
+}
+
+// Bool returns the value converted to a boolean.
+func (v Value) Bool() bool {
+	return v.v.toBool()
+}
+
+// Number returns the value converted to a number. It returns NaN if the
+// string of the value is not a number.
+func (v Value) Number() float64 {
+	return v.v.toNumber()
+}
+
+// String returns the value converted to a string.
+func (v Value) String() string {
+	return v.v.toString()
+}
+
+// Strings returns the strings of the value. The strings of a set of
+// elements are the texts of the elements implementing the Texter interface.
+func (v Value) Strings() []string {
+	return v.v.toStrings()
+}
+
+// Elements returns the elements of an ElementsKind value,
+// or nil for the other kinds.
+func (v Value) Elements() []Element {
+	return v.v.nodes
+}
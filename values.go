@@ -0,0 +1,65 @@
+package treepath
+
+// Values returns the values matched by the path: the values of the
+// attributes for a path ending with @attr or @*, the texts for a path
+// ending with text(), and the texts of the matched elements otherwise.
+// The elements must implement the Attributer and Texter interfaces.
+//
+// The values of each path of a union are returned in the Order of the
+// path, after the ones of the previous paths. The same value of the same
+// element is returned once. The $variables of the path are evaluated
+// as empty sets of attributes.
+//
+// The other methods return the elements having the values.
+func (path Path) Values(root Element) []string {
+	return readValues(root, path, newPather(path.limits))
+}
+
+// A valueRead is the value read by a path from the matched elements.
+type valueRead struct {
+	name string   // "@attr", "text()", or "" for the elements
+	attr nameTest // the name test of "@attr"
+}
+
+// A valueKey identifies a value read from an element.
+type valueKey struct {
+	e    Element
+	read string
+}
+
+// readValues evaluates each path of the union from the element e,
+// with the pather parent of the enclosing path, and returns the values
+// read from the matched elements.
+func readValues(e Element, path Path, parent *pather) []string {
+	values := make([]string, 0)
+	seen := make(map[valueKey]bool)
+	for j, segments := range path.paths {
+		p := parent.fork(path.limits)
+		results := p.traverse(e, Path{paths: [][]segment{segments}})
+		if parent.join(p); parent.err != nil {
+			break
+		}
+		sortElements(results, path.order)
+		for _, r := range results {
+			key := valueKey{r, path.reads[j].name}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			values = append(values, readValue(r, path.reads[j])...)
+		}
+	}
+	return values
+}
+
+// readValue returns the value read from the element e: the values of
+// the attributes for "@attr" or "@*", or the text otherwise.
+func readValue(e Element, read valueRead) []string {
+	if len(read.name) > 0 && read.name[0] == '@' {
+		return attrValues(e, read.attr)
+	}
+	if t, ok := textValue(e); ok {
+		return []string{t}
+	}
+	return nil
+}
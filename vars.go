@@ -0,0 +1,68 @@
+package treepath
+
+import "fmt"
+
+// Vars contains the values of the $variables of a path, by name
+// without the "$". A value can be a bool, a string, an int, a float64,
+// or a []string, that is compared as a set of attributes.
+// A variable bound to a string is compared as a 'text' value, as described
+// by CompilePath.
+type Vars map[string]interface{}
+
+// A VarError is returned when a variable of a path is not bound,
+// or it is bound to a value of an unsupported type.
+type VarError struct {
+	Name  string      // the name of the variable, without the "$"
+	Value interface{} // the value of the variable, or nil if it is not bound
+}
+
+// Error returns the string describing the variable error.
+func (err *VarError) Error() string {
+	if err.Value == nil {
+		return "treepath: variable $" + err.Name + " is not bound."
+	}
+	return fmt.Sprintf("treepath: variable $%s has unsupported type %T.", err.Name, err.Value)
+}
+
+// bind returns the values of the variables of the path.
+// It returns a *VarError if a variable is not bound or if its
+// value has an unsupported type.
+func (path Path) bind(vars Vars) (map[string]value, error) {
+	values := make(map[string]value, len(path.vars))
+	for _, name := range path.vars {
+		switch v := vars[name].(type) {
+		case bool:
+			values[name] = boolValue(v)
+		case string:
+			values[name] = stringValue(v)
+		case int:
+			values[name] = numberValue(float64(v))
+		case float64:
+			values[name] = numberValue(v)
+		case []string:
+			values[name] = stringsValue(v)
+		default:
+			return nil, &VarError{name, v}
+		}
+	}
+	return values, nil
+}
+
+// FindElementsWith is like FindElements, but it binds the $variables of
+// the path to the given values. The same path can be evaluated with
+// different values, also concurrently.
+// It returns a *VarError if a variable is not bound. If the Limits of the
+// path are exceeded, it returns the elements found so far together with
+// a *LimitError.
+// The other methods evaluate the variables as empty sets of attributes.
+func (path Path) FindElementsWith(root Element, vars Vars) ([]Element, error) {
+	values, err := path.bind(vars)
+	if err != nil {
+		return nil, err
+	}
+	p := newPather(path.limits)
+	p.vars = values
+	results := p.traverse(root, path)
+	sortElements(results, path.order)
+	return results, p.err
+}
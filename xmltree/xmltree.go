@@ -0,0 +1,268 @@
+// Package xmltree parses XML documents with encoding/xml in trees of
+// elements that can be searched with treepath.
+//
+// For example
+//
+//	doc, err := xmltree.Parse(r)
+//	if err != nil {
+//		return err
+//	}
+//	path, err := treepath.CompilePath("//book[@category='WEB']/title")
+//	if err != nil {
+//		return err
+//	}
+//	titles := path.FindElements(doc)
+package xmltree
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/mmbros/treepath"
+)
+
+// xmlSpace is the namespace URI bound to the xml prefix.
+const xmlSpace = "http://www.w3.org/XML/1998/namespace"
+
+// A Token is an item of the content of an element:
+// an *Element, a CharData or a Comment.
+type Token interface {
+	isToken()
+}
+
+// CharData is the character data of an element, with the entities
+// and the CDATA sections decoded.
+type CharData string
+
+// Comment is an XML comment, without the <!-- and --> delimiters.
+type Comment string
+
+// Attr is an attribute of an element.
+type Attr struct {
+	Prefix string   // the namespace prefix, as written, if any
+	Name   xml.Name // the namespace URI and the local name
+	Value  string
+}
+
+// Element is an element of an XML document. It implements the
+// treepath.Element interface, and the optional Tagger, Attributer,
+// Texter and NamespacedElement interfaces.
+//
+// The tags and attribute names are matched as written, with their
+// prefix, as soap:Body. The paths compiled with Namespaces match
+// them by namespace URI instead.
+type Element struct {
+	Prefix     string   // the namespace prefix of the tag, as written, if any
+	Name       xml.Name // the namespace URI and the local name of the tag
+	Attributes []Attr   // the attributes, with the xmlns declarations
+	Content    []Token  // the child elements, char data and comments, in order
+	parent     *Element
+}
+
+func (*Element) isToken() {}
+func (CharData) isToken() {}
+func (Comment) isToken()  {}
+
+var (
+	_ treepath.Element           = (*Element)(nil)
+	_ treepath.Tagger            = (*Element)(nil)
+	_ treepath.Attributer        = (*Element)(nil)
+	_ treepath.Texter            = (*Element)(nil)
+	_ treepath.NamespacedElement = (*Element)(nil)
+)
+
+// Parse parses the XML document read from r. It returns the document
+// element, without a tag, whose children are the top-level elements
+// of the document, so that the paths as ./html/body and //body can be
+// evaluated from it. Processing instructions and directives are skipped.
+func Parse(r io.Reader) (*Element, error) {
+	d := xml.NewDecoder(r)
+	doc := &Element{}
+	e := doc
+	scopes := []map[string]string{{"xml": xmlSpace}}
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string, len(scopes[len(scopes)-1]))
+			for prefix, space := range scopes[len(scopes)-1] {
+				scope[prefix] = space
+			}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					scope[""] = a.Value
+				case a.Name.Space == "xmlns":
+					scope[a.Name.Local] = a.Value
+				}
+			}
+			scopes = append(scopes, scope)
+			child := &Element{
+				Prefix:     t.Name.Space,
+				Name:       xml.Name{Space: resolve(scope, t.Name.Space, true), Local: t.Name.Local},
+				Attributes: make([]Attr, 0, len(t.Attr)),
+				parent:     e,
+			}
+			for _, a := range t.Attr {
+				child.Attributes = append(child.Attributes, Attr{
+					Prefix: a.Name.Space,
+					Name:   xml.Name{Space: resolve(scope, a.Name.Space, false), Local: a.Name.Local},
+					Value:  a.Value,
+				})
+			}
+			e.Content = append(e.Content, child)
+			e = child
+		case xml.EndElement:
+			if e == doc || t.Name.Space != e.Prefix || t.Name.Local != e.Name.Local {
+				return nil, errors.New("xmltree: unexpected end element </" + qualified(t.Name.Space, t.Name.Local) + ">")
+			}
+			scopes = scopes[:len(scopes)-1]
+			e = e.parent
+		case xml.CharData:
+			e.Content = append(e.Content, CharData(t))
+		case xml.Comment:
+			e.Content = append(e.Content, Comment(t))
+		}
+	}
+	if e != doc {
+		return nil, errors.New("xmltree: unexpected EOF in element <" + e.Tag() + ">")
+	}
+	return doc, nil
+}
+
+// resolve returns the namespace URI bound to the prefix in the scope.
+// The unprefixed names of the attributes have no namespace, and the
+// unknown prefixes are returned as they are, as encoding/xml does.
+func resolve(scope map[string]string, prefix string, tag bool) string {
+	switch {
+	case prefix == "xmlns":
+		return prefix
+	case prefix == "" && !tag:
+		return ""
+	}
+	if space, ok := scope[prefix]; ok {
+		return space
+	}
+	return prefix
+}
+
+// qualified returns the name with its prefix, if any.
+func qualified(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+// Parent returns the parent element, or nil for the document element.
+func (e *Element) Parent() treepath.Element {
+	if e.parent == nil {
+		return nil
+	}
+	return e.parent
+}
+
+// Children returns the child elements.
+func (e *Element) Children() []treepath.Element {
+	children := make([]treepath.Element, 0, len(e.Content))
+	for _, t := range e.Content {
+		if c, ok := t.(*Element); ok {
+			children = append(children, c)
+		}
+	}
+	return children
+}
+
+// MatchTag returns true if the element has the tag.
+func (e *Element) MatchTag(tag string) bool {
+	return e.Tag() == tag
+}
+
+// MatchTagText returns true if the element has the tag and the text.
+func (e *Element) MatchTagText(tag, text string) bool {
+	return e.MatchTag(tag) && e.Text() == text
+}
+
+// MatchAttr returns true if the element has the attribute.
+func (e *Element) MatchAttr(attr string) bool {
+	_, ok := e.Attr(attr)
+	return ok
+}
+
+// MatchAttrText returns true if the element has the attribute
+// with the value text.
+func (e *Element) MatchAttrText(attr, text string) bool {
+	v, ok := e.Attr(attr)
+	return ok && v == text
+}
+
+// Tag returns the tag of the element, with its prefix if any.
+func (e *Element) Tag() string {
+	return qualified(e.Prefix, e.Name.Local)
+}
+
+// Attrs returns the names of the attributes, with their prefix if any.
+func (e *Element) Attrs() []string {
+	names := make([]string, len(e.Attributes))
+	for j, a := range e.Attributes {
+		names[j] = qualified(a.Prefix, a.Name.Local)
+	}
+	return names
+}
+
+// Attr returns the value of the attribute with the name, with its
+// prefix if any, and whether the attribute was found.
+func (e *Element) Attr(name string) (string, bool) {
+	for _, a := range e.Attributes {
+		if qualified(a.Prefix, a.Name.Local) == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Text returns the character data of the element, without the one
+// of its descendants.
+func (e *Element) Text() string {
+	var b strings.Builder
+	for _, t := range e.Content {
+		if s, ok := t.(CharData); ok {
+			b.WriteString(string(s))
+		}
+	}
+	return b.String()
+}
+
+// TagNS returns the namespace URI and the local name of the tag.
+func (e *Element) TagNS() treepath.Name {
+	return treepath.Name(e.Name)
+}
+
+// AttrsNS returns the namespace URIs and the local names of the
+// attributes.
+func (e *Element) AttrsNS() []treepath.Name {
+	names := make([]treepath.Name, len(e.Attributes))
+	for j, a := range e.Attributes {
+		names[j] = treepath.Name(a.Name)
+	}
+	return names
+}
+
+// AttrNS returns the value of the attribute with the namespace URI and
+// the local name, and whether the attribute was found.
+func (e *Element) AttrNS(name treepath.Name) (string, bool) {
+	for _, a := range e.Attributes {
+		if treepath.Name(a.Name) == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
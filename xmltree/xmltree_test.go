@@ -0,0 +1,87 @@
+package xmltree
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mmbros/treepath"
+)
+
+const xmlDoc = `<?xml version="1.0"?>
+<!-- a catalog -->
+<s:Envelope xmlns:s="urn:soap" xmlns="urn:books" xmlns:x="urn:ext">
+	<s:Body>
+		<book id="1" x:lang="en"><title>Go &amp; XML</title><!-- first --></book>
+		<book id="2"><title><![CDATA[<Trees>]]></title>text</book>
+	</s:Body>
+</s:Envelope>`
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(strings.NewReader(xmlDoc))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	if len(doc.Content) != 4 || doc.Content[1] != Comment(" a catalog ") || doc.Parent() != nil {
+		t.Fatalf("unexpected document content %v", doc.Content)
+	}
+
+	env := doc.Children()[0].(*Element)
+	if env.Tag() != "s:Envelope" || env.Name.Space != "urn:soap" || env.Parent() != treepath.Element(doc) {
+		t.Errorf("unexpected envelope %s {%s}", env.Tag(), env.Name.Space)
+	}
+
+	for _, test := range []struct {
+		path   string
+		values []string
+	}{
+		{"./s:Envelope/s:Body/book/title", []string{"Go & XML", "<Trees>"}},
+		{"//book[@id='2']/text()", []string{"text"}},
+		{"//book[title='<Trees>']/@id", []string{"2"}},
+		{"//book[*='<Trees>']/@id", []string{"2"}},
+		{"//book/@x:lang", []string{"en"}},
+		{"/s:Envelope/@*", []string{"urn:soap", "urn:books", "urn:ext"}},
+		{"//Body", nil},
+	} {
+		path, err := treepath.CompilePath(test.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.path, err)
+			continue
+		}
+		if values := path.Values(doc); len(values) != len(test.values) || len(values) > 0 && !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: expected %q, found %q", test.path, test.values, values)
+		}
+	}
+
+	path, err := treepath.CompilePathWith("//soap:Body/b:book[1]", treepath.CompileOptions{
+		Namespaces: map[string]string{"soap": "urn:soap", "b": "urn:books"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	found, ok := path.FindElement(doc)
+	if !ok {
+		t.Fatalf("%s: element not found", path)
+	}
+	book := found.(*Element)
+	if len(book.Content) != 2 || book.Content[1] != Comment(" first ") {
+		t.Errorf("unexpected content %v", book.Content)
+	}
+	if lang, _ := book.AttrNS(treepath.Name{Space: "urn:ext", Local: "lang"}); lang != "en" {
+		t.Errorf("unexpected x:lang %q", lang)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, doc := range []string{
+		"<a><b></a>",
+		"<a>",
+		"</a>",
+		"<a x='1>",
+	} {
+		if _, err := Parse(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: expected an error", doc)
+		}
+	}
+}